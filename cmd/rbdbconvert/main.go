@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"rbdbtools/internal/app/rbdbconvert"
+	"rbdbtools/pkg/logger"
+	"rbdbtools/tools"
+)
+
+func main() {
+	in := flag.String("in", "./.rockbox/", "directory containing database files")
+	out := flag.String("out", "", "directory to save the converted database to (default the input directory)")
+	endian := flag.String("endian", "swap", "byte order to convert to, big, little or swap")
+	version := flag.String("version", "", "format version to convert to, such as 0x5443480E (default the input's version)")
+	flag.Parse()
+
+	if *out == "" {
+		*out = *in
+	}
+
+	log := logger.New()
+	if !tools.DirExists(*in) {
+		log.Fatal("input directory does not exist")
+	} else {
+		rbdbconvert.Rbdbconvert(*in, *out, *endian, *version)
+	}
+}
@@ -4,8 +4,13 @@ import (
 	"errors"
 	"flag"
 	"rbdbtools/internal/app/rbdbgen"
+	"rbdbtools/pkg/compilation"
+	"rbdbtools/pkg/database"
+	"rbdbtools/pkg/device"
 	"rbdbtools/pkg/logger"
 	"rbdbtools/tools"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -13,16 +18,118 @@ func main() {
 	t := flag.String("target", "./database/", "directory to output database files to (will be created if not exists)")
 	i := flag.String("internal", "", "location of music on internal media")
 	e := flag.String("external", "", "location of music on external media")
+	o := flag.String("overrides", "", "json file of tag overrides keyed by path or glob relative to the music location")
+	va := flag.String("various", compilation.DefaultVariousArtists, "album artist to assign to compilations")
+	vaMin := flag.Int("compilationartists", 0, "treat albums in one directory with at least this many artists as compilations (0 to disable)")
+	lang := flag.String("collate", "", "language to order tags by, e.g. sv or de (default root collation)")
+	articles := flag.String("articles", strings.Join(database.DefaultArticles, ","), "comma separated leading articles to ignore when ordering")
+	sortTags := flag.Bool("sorttags", true, "order by ARTISTSORT, ALBUMSORT etc. when present")
+	untaggedLast := flag.Bool("untaggedlast", false, "order <Untagged> after all other values")
+	normalize := flag.String("normalize", "nfc", "unicode normalization of tags and filenames (nfc, nfd or none)")
+	update := flag.String("update", "", "directory of an existing database (e.g. .rockbox) to update instead of starting over")
+	compact := flag.Bool("compact", false, "drop deleted tracks from an updated database instead of flagging them deleted")
+	backups := flag.Int("backups", database.DefaultBackups, "number of previous databases to keep as backups in the target directory")
+	dev := flag.String("device", "", "mount point of a player to install the database onto, -rockbox and -internal default to its .rockbox directory and root")
+	rockbox := flag.String("rockbox", "", "the player's .rockbox directory to detect endianness and format version from")
+	tz := flag.String("tz", "Local", "time zone of the player's clock, e.g. UTC or Europe/Berlin")
 	flag.Parse()
 
 	log := logger.New()
+	normalization, err := database.ParseNormalization(*normalize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	timeZone, err := time.LoadLocation(*tz)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var mount *device.Mount
+	internalRoot := ""
+	if *dev != "" {
+		m, err := device.FindMount(*dev)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *rockbox == "" {
+			*rockbox = m.RockboxDir
+		}
+		if *i == "" {
+			*i = m.Root
+		} else if *i, err = m.Within(*i); err != nil {
+			log.Fatal(err)
+		}
+		mount, internalRoot = &m, m.Root
+	}
+
+	bigEndian, version, externalVolume := *big, uint32(0), ""
+	if *rockbox != "" {
+		profile, err := device.Detect(*rockbox)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if flagSet("big") && *big != profile.BigEndian {
+			log.Fatalf("-big contradicts the player: %s", profile)
+		}
+		if *e != "" && profile.ExternalVolume == "" {
+			log.Fatalf("player has no external media: %s", profile)
+		}
+		log.Infof("Building for %s", profile)
+		bigEndian, version, externalVolume = profile.BigEndian, profile.Version, profile.ExternalVolume
+	}
+
+	collation := database.Collation{
+		Language:     *lang,
+		Articles:     make([]string, 0),
+		SortTags:     *sortTags,
+		UntaggedLast: *untaggedLast,
+	}
+	for _, a := range strings.Split(*articles, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			collation.Articles = append(collation.Articles, a)
+		}
+	}
+
 	if *i == "" && *e == "" {
 		log.Fatal(errors.New("internal and/or external must be specified"))
 	} else if *i != "" && !tools.DirExists(*i) {
 		log.Fatal("internal directory does not exist")
 	} else if *e != "" && !tools.DirExists(*e) {
 		log.Fatal("external directory does not exist")
+	} else if *update != "" && !tools.DirExists(*update) {
+		log.Fatal("update directory does not exist")
 	} else {
-		rbdbgen.Rbdbgen(*big, *t, *i, *e)
+		rbdbgen.Rbdbgen(rbdbgen.Options{
+			BigEndian:        bigEndian,
+			TargetDir:        *t,
+			InternalTrackDir: *i,
+			ExternalTrackDir: *e,
+			OverridesFile:    *o,
+			Compilations: compilation.Options{
+				VariousArtists: *va,
+				MinArtists:     *vaMin,
+			},
+			Collation:      collation,
+			Normalization:  normalization,
+			TimeZone:       timeZone,
+			UpdateDir:      *update,
+			Compact:        *compact,
+			Backups:        *backups,
+			Version:        version,
+			ExternalVolume: externalVolume,
+			InternalRoot:   internalRoot,
+			Device:         mount,
+		})
 	}
 }
+
+// flagSet is whether the named flag was given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"flag"
+	"rbdbtools/internal/app/rbdbrestore"
+	"rbdbtools/pkg/logger"
+	"rbdbtools/tools"
+)
+
+func main() {
+	dir := flag.String("dir", "./database/", "directory containing database files and their backups")
+	backup := flag.String("backup", "", "name of the backup to restore (default the newest)")
+	list := flag.Bool("list", false, "list backups instead of restoring one")
+	flag.Parse()
+
+	log := logger.New()
+	if !tools.DirExists(*dir) {
+		log.Fatal("database directory does not exist")
+	} else {
+		rbdbrestore.Rbdbrestore(*dir, *backup, *list)
+	}
+}
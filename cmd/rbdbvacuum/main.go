@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"rbdbtools/internal/app/rbdbvacuum"
+	"rbdbtools/pkg/logger"
+	"rbdbtools/tools"
+)
+
+func main() {
+	in := flag.String("in", "./.rockbox/", "directory containing database files")
+	out := flag.String("out", "", "directory to save the vacuumed database to (default the input directory)")
+	flag.Parse()
+
+	if *out == "" {
+		*out = *in
+	}
+
+	log := logger.New()
+	if !tools.DirExists(*in) {
+		log.Fatal("input directory does not exist")
+	} else {
+		rbdbvacuum.Rbdbvacuum(*in, *out)
+	}
+}
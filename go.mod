@@ -1,8 +1,9 @@
 module rbdbtools
 
-go 1.15
+go 1.17
 
 require (
 	github.com/gocarina/gocsv v0.0.0-20201208093247-67c824bc04d4
 	github.com/tealeg/xlsx v1.0.5
+	golang.org/x/text v0.13.0
 )
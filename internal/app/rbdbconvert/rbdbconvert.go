@@ -0,0 +1,71 @@
+package rbdbconvert
+
+import (
+	"fmt"
+	"os"
+	"rbdbtools/pkg/database"
+	"rbdbtools/pkg/decoder"
+	"rbdbtools/pkg/logger"
+	"rbdbtools/tools"
+	"strconv"
+)
+
+var (
+	log = logger.New()
+)
+
+// Rbdbconvert converts the database in dbPath to endian, one of big, little or
+// swap, and to version, keeping its version when empty
+func Rbdbconvert(dbPath string, outPath string, endian string, version string) {
+	if !tools.DirExists(outPath) {
+		err := os.MkdirAll(outPath, os.ModePerm)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	header, bigEndian, err := decoder.DecodeIndexHeader(dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	toBigEndian, err := parseEndian(endian, bigEndian)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	toVersion := header.Magic
+	if version != "" {
+		v, err := strconv.ParseUint(version, 0, 32)
+		if err != nil {
+			log.Fatalf("Invalid version %q: %s", version, err)
+		}
+		toVersion = uint32(v)
+	}
+
+	log.Infof("Converting %s from %s 0x%08X to %s 0x%08X", dbPath, endianName(bigEndian), header.Magic, endianName(toBigEndian), toVersion)
+	err = database.Convert(dbPath, outPath, toBigEndian, toVersion)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Saved to %s and verified %d entries", outPath, header.Header.Entries)
+}
+
+func parseEndian(endian string, bigEndian bool) (bool, error) {
+	switch endian {
+	case "big":
+		return true, nil
+	case "little":
+		return false, nil
+	case "swap":
+		return !bigEndian, nil
+	}
+	return false, fmt.Errorf("endian must be big, little or swap, not %q", endian)
+}
+
+func endianName(bigEndian bool) string {
+	if bigEndian {
+		return "big endian"
+	}
+	return "little endian"
+}
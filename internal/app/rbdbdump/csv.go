@@ -14,37 +14,35 @@ const (
 	indexHeaderCSV = "index_header"
 	indexCSV       = "index"
 	indexTagsCSV   = "indexTags"
+	conflictsCSV   = "normalization"
 )
 
 var (
 	log = logger.New()
 )
 
-func csv(dbPath string, outPath string) {
-	databases, err := decoder.DecodeDatabases(dbPath)
-	if err != nil {
-		log.Fatal(err)
-	}
-
+func csv(databases *decoder.DecodedDatabases, conflicts []decoder.NormalizationConflict, outPath string) {
 	headers := databases.GetHeaders()
-	err = writeCSV(&headers, path.Join(outPath, headersCSV+".csv"))
-	if err != nil {
-		log.Error(err)
-	}
-	indexHeader := databases.GetIndexHeader()
-	err = writeCSV(&indexHeader, path.Join(outPath, indexHeaderCSV+".csv"))
+	err := writeCSV(&headers, path.Join(outPath, headersCSV+".csv"))
 	if err != nil {
 		log.Error(err)
 	}
-	indexEntries := databases.GetIndexOffsets()
-	err = writeCSV(&indexEntries, path.Join(outPath, indexCSV+".csv"))
-	if err != nil {
-		log.Error(err)
-	}
-	indexEntries = databases.GetIndexTags()
-	err = writeCSV(&indexEntries, path.Join(outPath, indexTagsCSV+".csv"))
-	if err != nil {
-		log.Error(err)
+	if databases.HasIndex() {
+		indexHeader := databases.GetIndexHeader()
+		err = writeCSV(&indexHeader, path.Join(outPath, indexHeaderCSV+".csv"))
+		if err != nil {
+			log.Error(err)
+		}
+		indexEntries := databases.GetIndexOffsets()
+		err = writeCSV(&indexEntries, path.Join(outPath, indexCSV+".csv"))
+		if err != nil {
+			log.Error(err)
+		}
+		indexEntries = databases.GetIndexTags()
+		err = writeCSV(&indexEntries, path.Join(outPath, indexTagsCSV+".csv"))
+		if err != nil {
+			log.Error(err)
+		}
 	}
 	for _, s := range databases.GetDatabases() {
 		err = writeCSV(databases.GetEntries(s), path.Join(outPath, fmt.Sprintf("%s.csv", s)))
@@ -52,6 +50,12 @@ func csv(dbPath string, outPath string) {
 			log.Error(err)
 		}
 	}
+	if len(conflicts) > 0 {
+		err = writeCSV(&conflicts, path.Join(outPath, conflictsCSV+".csv"))
+		if err != nil {
+			log.Error(err)
+		}
+	}
 }
 
 func writeCSV(t interface{}, filename string) error {
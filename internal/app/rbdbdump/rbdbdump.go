@@ -2,10 +2,14 @@ package rbdbdump
 
 import (
 	"os"
+	"rbdbtools/pkg/decoder"
 	"rbdbtools/tools"
+	"strings"
 )
 
-func Rbdbdump(dbPath string, outPath string, toCsv bool) {
+// Rbdbdump dumps the files of the database in dbPath, or only those named in files,
+// skipping any that are missing
+func Rbdbdump(dbPath string, outPath string, toCsv bool, files []string) {
 	if !tools.DirExists(outPath) {
 		err := os.MkdirAll(outPath, os.ModePerm)
 		if err != nil {
@@ -13,9 +17,34 @@ func Rbdbdump(dbPath string, outPath string, toCsv bool) {
 		}
 	}
 
+	databases, err := decoder.DecodeDatabasesWith(dbPath, decoder.Options{
+		Files:        files,
+		AllowMissing: true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	endian := "little"
+	if databases.BigEndian {
+		endian = "big"
+	}
+	log.Infof("Database is %s endian, version 0x%08X", endian, databases.Version)
+
+	if len(databases.Missing) > 0 {
+		log.Warningf("Cannot find databases, dumping the rest: %s", strings.Join(databases.Missing, ", "))
+	}
+	if len(databases.Invalid) > 0 {
+		log.Warningf("Databases are too short to have a header, dumping the rest: %s", strings.Join(databases.Invalid, ", "))
+	}
+
+	conflicts := databases.GetNormalizationConflicts()
+	for _, e := range conflicts {
+		log.Warningf("%s: %q at %s only differs from %q at %s by unicode normalization", e.Database, e.Data, e.Offset, e.ConflictData, e.ConflictOffset)
+	}
+
 	if toCsv {
-		csv(dbPath, outPath)
+		csv(databases, conflicts, outPath)
 	} else {
-		toXlsx(dbPath, outPath)
+		toXlsx(databases, conflicts, outPath)
 	}
 }
@@ -12,12 +12,7 @@ const (
 	xlsxOut = "tagcache.xlsx"
 )
 
-func toXlsx(dbPath string, outPath string) {
-	databases, err := decoder.DecodeDatabases(dbPath)
-	if err != nil {
-		log.Fatal(err)
-	}
-
+func toXlsx(databases *decoder.DecodedDatabases, conflicts []decoder.NormalizationConflict, outPath string) {
 	spreadsheet := xlsx.NewFile()
 
 	headers := databases.GetHeaders()
@@ -30,34 +25,36 @@ func toXlsx(dbPath string, outPath string) {
 		log.Fatal(err)
 	}
 
-	indexHeader := databases.GetIndexHeader()
-	indexHeaderSheet, err := spreadsheet.AddSheet(indexHeaderCSV)
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = addToSheet(indexHeaderSheet, indexHeader)
-	if err != nil {
-		log.Fatal(err)
-	}
+	if databases.HasIndex() {
+		indexHeader := databases.GetIndexHeader()
+		indexHeaderSheet, err := spreadsheet.AddSheet(indexHeaderCSV)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = addToSheet(indexHeaderSheet, indexHeader)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	indexEntries := databases.GetIndexOffsets()
-	indexOffsetsSheet, err := spreadsheet.AddSheet(indexCSV)
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = addToSheet(indexOffsetsSheet, indexEntries)
-	if err != nil {
-		log.Fatal(err)
-	}
+		indexEntries := databases.GetIndexOffsets()
+		indexOffsetsSheet, err := spreadsheet.AddSheet(indexCSV)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = addToSheet(indexOffsetsSheet, indexEntries)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	indexEntries = databases.GetIndexTags()
-	indexTagsSheet, err := spreadsheet.AddSheet(indexTagsCSV)
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = addToSheet(indexTagsSheet, indexEntries)
-	if err != nil {
-		log.Fatal(err)
+		indexEntries = databases.GetIndexTags()
+		indexTagsSheet, err := spreadsheet.AddSheet(indexTagsCSV)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = addToSheet(indexTagsSheet, indexEntries)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	for _, s := range databases.GetDatabases() {
@@ -71,6 +68,17 @@ func toXlsx(dbPath string, outPath string) {
 		}
 	}
 
+	if len(conflicts) > 0 {
+		conflictsSheet, err := spreadsheet.AddSheet(conflictsCSV)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = addToSheet(conflictsSheet, conflicts)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	err = spreadsheet.Save(path.Join(outPath, xlsxOut))
 	if err != nil {
 		log.Fatal(err)
@@ -1,16 +1,21 @@
 package rbdbgen
 
 import (
+	"fmt"
 	"math"
 	"os"
 	"path"
 	"path/filepath"
 	"rbdbtools/pkg/cache"
+	"rbdbtools/pkg/compilation"
 	"rbdbtools/pkg/database"
+	"rbdbtools/pkg/device"
 	"rbdbtools/pkg/logger"
+	"rbdbtools/pkg/overrides"
 	"rbdbtools/pkg/track"
 	"rbdbtools/tools"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -29,7 +34,51 @@ func timer(t time.Time) {
 	log.Infof("Created database in %s", time.Since(t))
 }
 
-func Rbdbgen(bigEndian bool, targetDir string, internalTrackDir string, externalTrackDir string) {
+type Options struct {
+	BigEndian        bool
+	TargetDir        string
+	InternalTrackDir string
+	ExternalTrackDir string
+	// OverridesFile is an optional central tag override file
+	OverridesFile string
+	// Compilations configures detection of various artists albums
+	Compilations compilation.Options
+	// Collation configures the ordering of the index and tag files
+	Collation database.Collation
+	// Normalization is the unicode form tags and filenames are written in
+	Normalization database.Normalization
+	// TimeZone is the time zone of the player's clock
+	TimeZone *time.Location
+	// UpdateDir is the directory of a database to update instead of starting over
+	UpdateDir string
+	// Compact drops deleted tracks instead of keeping them flagged deleted
+	Compact bool
+	// Backups is the number of previous databases to keep in the target directory
+	Backups int
+	// Version is the format version to write, the latest when 0. When set, a
+	// database to update must already be of this version and BigEndian.
+	Version uint32
+	// ExternalVolume is the name the player gives its card, device.DefaultExternalVolume when empty
+	ExternalVolume string
+	// InternalRoot is the directory the player sees as the root of its internal
+	// volume, InternalTrackDir when empty
+	InternalRoot string
+	// Device is a mounted player to install the database onto as well, if any
+	Device *device.Mount
+}
+
+// endianName is "big" or "little"
+func endianName(bigEndian bool) string {
+	if bigEndian {
+		return "big"
+	}
+	return "little"
+}
+
+func Rbdbgen(opts Options) {
+	bigEndian, targetDir := opts.BigEndian, opts.TargetDir
+	internalTrackDir, externalTrackDir := opts.InternalTrackDir, opts.ExternalTrackDir
+
 	err := os.MkdirAll(targetDir, os.ModePerm)
 	if err != nil {
 		log.Fatal(err)
@@ -38,16 +87,62 @@ func Rbdbgen(bigEndian bool, targetDir string, internalTrackDir string, external
 	log.Info("Starting...")
 	defer timer(time.Now())
 
+	timeZone := time.Local
+	if opts.TimeZone != nil {
+		timeZone = opts.TimeZone
+	}
+
+	update := opts.UpdateDir != ""
 	db := database.New(bigEndian)
+	if update {
+		log.Infof("Loading database to update from '%s'...", opts.UpdateDir)
+		db, err = database.Load(opts.UpdateDir, timeZone)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if opts.Version != 0 && (db.BigEndian() != bigEndian || db.FormatVersion() != opts.Version) {
+			log.Fatal(fmt.Errorf("%w: '%s' is %s endian with format version 0x%08X", device.IncompatibleDatabaseError,
+				opts.UpdateDir, endianName(db.BigEndian()), db.FormatVersion()))
+		}
+		bigEndian = db.BigEndian()
+	}
+
+	if opts.Version != 0 && !update {
+		err = db.SetFormatVersion(opts.Version)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	externalVolume := device.DefaultExternalVolume
+	if opts.ExternalVolume != "" {
+		externalVolume = opts.ExternalVolume
+	}
+
+	db.SetBackups(opts.Backups)
+	db.SetNormalization(opts.Normalization)
+	db.SetTimeZone(timeZone)
+	err = db.SetCollation(opts.Collation)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	summary := changes{}
 
 	oldCacheSize, newCacheSize := 0, 0
 	if internalTrackDir != "" {
 		o, n := loadTracksIntoDB(loadTracksIntoDBParams{
 			tracksPath:    internalTrackDir,
+			volumeRoot:    opts.InternalRoot,
 			targetDir:     targetDir,
 			cacheLocation: internalCacheName,
+			overridesFile: opts.OverridesFile,
+			compilations:  opts.Compilations,
 			external:      false,
 			database:      &db,
+			update:        update,
+			timeZone:      timeZone,
+			changes:       &summary,
 		})
 		oldCacheSize += o
 		newCacheSize += n
@@ -58,26 +153,79 @@ func Rbdbgen(bigEndian bool, targetDir string, internalTrackDir string, external
 			tracksPath:    externalTrackDir,
 			targetDir:     targetDir,
 			cacheLocation: externalCacheName,
+			overridesFile: opts.OverridesFile,
+			compilations:  opts.Compilations,
 			external:      true,
+			volume:        externalVolume,
 			database:      &db,
+			update:        update,
+			timeZone:      timeZone,
+			changes:       &summary,
 		})
 		oldCacheSize += o
 		newCacheSize += n
 	}
 
-	endian := "little"
-	if bigEndian {
-		endian = "big"
+	log.Infof("Saving database in %s endian format...", endianName(bigEndian))
+
+	if opts.Compact {
+		log.Infof("Compacted %d deleted tracks", db.Compact())
+	}
+
+	// Start from the previous state, it is only advanced if the database changed
+	// so rebuilding an unchanged library gives identical files. A player's own
+	// database is the previous one when installing onto it, it has the serial
+	// the player advanced.
+	previousDir := targetDir
+	if opts.Device != nil {
+		previousDir = opts.Device.RockboxDir
+	}
+	state, existing := db.State(), update
+	if !update {
+		if state, err = database.LoadState(previousDir); err == nil {
+			existing = true
+		} else {
+			if !os.IsNotExist(err) {
+				log.Warningf("Could not read previous database, starting a new one: %s", err)
+			}
+			state = database.NewState()
+		}
+	}
+	db.SetState(state)
+
+	manifest, err := db.Manifest()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if previous, err := database.ReadManifest(previousDir); err == nil && len(manifest.Diff(previous)) == 0 {
+		log.Infof("Database is identical to the one already in '%s'", previousDir)
+	} else if existing {
+		state = state.Next()
+		db.SetState(state)
 	}
-	log.Infof("Saving database in %s endian format...", endian)
+	log.Infof("Saving with serial %d and commit id %d", state.Serial, state.CommitId)
 
-	err = db.Save(targetDir)
+	written, err := db.Save(targetDir)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if len(written) == 0 {
+		log.Info("No files needed to be written")
+	} else {
+		log.Infof("Wrote %s", strings.Join(written, ", "))
+	}
+
+	if opts.Device != nil {
+		installOnDevice(&db, *opts.Device)
+	}
 
 	log.Infof("Cache increased by %d, cache size is now %d", newCacheSize-oldCacheSize, newCacheSize)
 
+	if update {
+		log.Infof("Added %d, resurrected %d, deleted %d, refreshed %d and kept %d unchanged tracks",
+			summary.added, summary.resurrected, summary.deleted, summary.refreshed, summary.unchanged)
+	}
+
 	for k, v := range db.Entries() {
 		log.Infof("Database has %d %s entries, a recorded size of %d bytes, and an actual size of %d bytes", v.Header.Entries, k, v.Header.Size, v.Size)
 	}
@@ -87,38 +235,81 @@ func Rbdbgen(bigEndian bool, targetDir string, internalTrackDir string, external
 
 type loadTracksIntoDBParams struct {
 	tracksPath    string
+	volumeRoot    string
 	targetDir     string
 	cacheLocation string
+	overridesFile string
+	compilations  compilation.Options
 	external      bool
+	volume        string
 	database      *database.Database
+	update        bool
+	timeZone      *time.Location
+	changes       *changes
 }
 
 func loadTracksIntoDB(params loadTracksIntoDBParams) (int, int) {
 	location := ""
 	locationName := "internal"
 	if params.external {
-		location = "<microSD1>"
+		location = params.volume
 		locationName = "external"
 	}
 
+	volumeRoot := params.tracksPath
+	if params.volumeRoot != "" {
+		volumeRoot = params.volumeRoot
+	}
+
 	log.Infof("Loading %s cache...", locationName)
-	c, err := cache.New(path.Join(params.targetDir, params.cacheLocation), params.tracksPath, location)
+	c, err := cache.New(path.Join(params.targetDir, params.cacheLocation), volumeRoot, location)
 	if err != nil {
 		log.Error(err)
 	}
 	oldSize := c.Size()
 
+	o, err := overrides.New(params.tracksPath, params.overridesFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.SetOverrides(&o)
+
 	log.Infof("Adding %s storage tracks from '%s'", locationName, params.tracksPath)
 	fileList, err := getTracks(params.tracksPath)
 	if err != nil {
 		log.Error(err)
 	}
 
+	// Tags of unchanged tracks are needed too, compilations are detected by
+	// directory. They come from the cache unless it was lost.
+	modified := make(map[string]bool)
+	if params.update {
+		for _, f := range diffTracks(params.database, &c, fileList, location, params.timeZone, params.changes) {
+			modified[c.DevicePath(f)] = true
+		}
+	}
+
 	log.Info("Getting tags for tracks...")
 	tagList, err := getTags(fileList, c)
 
-	log.Info("Adding tracks to database...")
-	params.database.Add(tagList...)
+	if n := compilation.Detect(tagList, params.compilations); n > 0 {
+		log.Infof("Assigned %d compilation tracks to %s", n, params.compilations.VariousArtists)
+	}
+
+	if params.update {
+		log.Info("Updating tracks in database...")
+		variousArtists := params.compilations.VariousArtists
+		if variousArtists == "" {
+			variousArtists = compilation.DefaultVariousArtists
+		}
+		updateTracks(params.database, changedTracks(params.database, tagList, modified, variousArtists, params.changes), params.changes)
+	} else {
+		log.Info("Adding tracks to database...")
+		err = params.database.Add(tagList...)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	log.Info("Saving cache...")
 	err = c.Save()
@@ -128,6 +319,25 @@ func loadTracksIntoDB(params loadTracksIntoDBParams) (int, int) {
 	return oldSize, c.Size()
 }
 
+// installOnDevice replaces the database on a mounted player, the one there is
+// kept as a backup along with the leftovers of it
+func installOnDevice(db *database.Database, mount device.Mount) {
+	if leftovers := mount.Leftovers(); len(leftovers) > 0 {
+		log.Warningf("Moving %s left on the player by its previous database into its backup", strings.Join(leftovers, ", "))
+	}
+
+	log.Infof("Installing database into '%s'...", mount.RockboxDir)
+	written, err := db.Install(mount.RockboxDir, device.Leftovers())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(written) == 0 {
+		log.Info("Database on the player was already up to date")
+	} else {
+		log.Infof("Installed %s", strings.Join(written, ", "))
+	}
+}
+
 func getTracks(root string) ([]string, error) {
 	i := 0
 	tracks := make([]string, 0)
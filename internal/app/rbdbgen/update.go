@@ -0,0 +1,121 @@
+package rbdbgen
+
+import (
+	"os"
+	"rbdbtools/pkg/cache"
+	"rbdbtools/pkg/database"
+	"rbdbtools/pkg/tcformat"
+	"rbdbtools/pkg/track"
+	"strings"
+	"time"
+)
+
+type changes struct {
+	added       int
+	resurrected int
+	deleted     int
+	refreshed   int
+	unchanged   int
+}
+
+// diffTracks marks tracks stored on the same volume as files that no longer
+// exist deleted and returns the files that are new or were modified since they
+// were added. volume is the name of the volume, empty for internal storage.
+func diffTracks(db *database.Database, c *cache.Cache, files []string, volume string, timeZone *time.Location, ch *changes) []string {
+	found := make(map[string]bool)
+	modified := make([]string, 0)
+	for _, f := range files {
+		t, exists := db.Get(c.DevicePath(f))
+		if exists {
+			// The database's own filename, it may differ by normalisation
+			found[t.Filename] = true
+		}
+
+		if exists && t.Flags&tcformat.FlagDeleted == 0 && !isModified(f, t, timeZone, db.FormatVersion()) {
+			ch.unchanged++
+		} else {
+			modified = append(modified, f)
+		}
+	}
+
+	for _, t := range db.Tracks() {
+		if t.Flags&tcformat.FlagDeleted != 0 || !onVolume(t.Filename, volume) || found[t.Filename] {
+			continue
+		}
+
+		if err := db.Delete(t.Filename); err != nil {
+			log.Error(err)
+		} else {
+			ch.deleted++
+		}
+	}
+
+	return modified
+}
+
+// onVolume is whether the player's path filename is on volume, internal storage
+// paths start with a slash and the others with their volume's name
+func onVolume(filename string, volume string) bool {
+	if volume == "" {
+		return strings.HasPrefix(filename, "/")
+	}
+	return strings.HasPrefix(filename, volume)
+}
+
+// isModified compares mtimes the way the player does, at the resolution the
+// database's format version stores
+func isModified(filename string, t track.Track, timeZone *time.Location, version uint32) bool {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return true
+	}
+
+	mtime := uint32(info.ModTime().Unix())
+	return tcformat.EncodeMtime(mtime, timeZone, version) != tcformat.EncodeMtime(t.Mtime, timeZone, version)
+}
+
+// changedTracks picks what to update the database with from every track on a
+// volume: the modified tracks, and unchanged ones that became or stopped being
+// part of a compilation because tracks were added to or removed from their directory
+func changedTracks(db *database.Database, tracks []track.Track, modified map[string]bool, variousArtists string, ch *changes) []track.Track {
+	changed := make([]track.Track, 0, len(modified))
+	for _, t := range tracks {
+		if modified[t.Filename] {
+			changed = append(changed, t)
+		} else if old, exists := db.Get(t.Filename); exists && (old.AlbumArtist == variousArtists) != (t.AlbumArtist == variousArtists) {
+			changed = append(changed, t)
+			ch.unchanged--
+		}
+	}
+	return changed
+}
+
+// updateTracks adds new tracks and replaces modified ones, keeping their statistics and flags,
+// tracks that were deleted are resurrected
+func updateTracks(db *database.Database, tracks []track.Track, ch *changes) {
+	for _, t := range tracks {
+		old, exists := db.Get(t.Filename)
+		if exists && old.Flags&tcformat.FlagDeleted == 0 {
+			t.PlayCount = old.PlayCount
+			t.Rating = old.Rating
+			t.PlayTime = old.PlayTime
+			t.LastPlayed = old.LastPlayed
+			t.LastElapsed = old.LastElapsed
+			t.LastOffset = old.LastOffset
+			t.CommitId = old.CommitId
+			t.Flags = old.Flags &^ tcformat.FlagDeleted
+
+			if err := db.Update(t); err != nil {
+				log.Error(err)
+			} else {
+				ch.refreshed++
+			}
+		} else if err := db.Add(t); err != nil {
+			log.Error(err)
+		} else if exists {
+			ch.resurrected++
+		} else {
+			ch.added++
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package rbdbrestore
+
+import (
+	"rbdbtools/pkg/database"
+	"rbdbtools/pkg/logger"
+)
+
+var (
+	log = logger.New()
+)
+
+func Rbdbrestore(dbPath string, backup string, list bool) {
+	backups, err := database.Backups(dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if list {
+		for _, e := range backups {
+			log.Info(e)
+		}
+		return
+	}
+
+	if backup == "" {
+		if len(backups) == 0 {
+			log.Fatal("there are no backups to restore")
+		}
+		backup = backups[len(backups)-1]
+	}
+
+	log.Infof("Restoring backup %s...", backup)
+	err = database.Restore(dbPath, backup)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Info("Restored, the replaced database was backed up")
+}
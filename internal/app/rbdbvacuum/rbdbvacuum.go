@@ -0,0 +1,33 @@
+package rbdbvacuum
+
+import (
+	"os"
+	"rbdbtools/pkg/database"
+	"rbdbtools/pkg/logger"
+	"rbdbtools/tools"
+)
+
+var (
+	log = logger.New()
+)
+
+func Rbdbvacuum(dbPath string, outPath string) {
+	if !tools.DirExists(outPath) {
+		err := os.MkdirAll(outPath, os.ModePerm)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	savings, err := database.Vacuum(dbPath, outPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	total := 0
+	for _, e := range savings {
+		log.Infof("%s: %s -> %s, saved %s", e.Filename, tools.BytesToFormalSize(e.Before), tools.BytesToFormalSize(e.After), tools.BytesToFormalSize(e.Saved()))
+		total += e.Saved()
+	}
+	log.Infof("Saved %s in total", tools.BytesToFormalSize(total))
+}
@@ -6,8 +6,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"rbdbtools/pkg/overrides"
 	"rbdbtools/pkg/track"
-	"strings"
 )
 
 type Cache struct {
@@ -15,6 +16,7 @@ type Cache struct {
 	rootPath  string
 	newPrefix string
 	cache     map[string]track.Track
+	overrides *overrides.Overrides
 }
 
 func New(cacheLocation string, rootPath string, newPrefix string) (Cache, error) {
@@ -42,6 +44,11 @@ func New(cacheLocation string, rootPath string, newPrefix string) (Cache, error)
 	}
 }
 
+// SetOverrides sets tag overrides to apply to tracks as they are added
+func (c *Cache) SetOverrides(o *overrides.Overrides) {
+	c.overrides = o
+}
+
 func (c *Cache) Add(filenames ...string) ([]track.Track, error, int) {
 	notRead := 0
 
@@ -54,12 +61,13 @@ func (c *Cache) Add(filenames ...string) ([]track.Track, error, int) {
 
 	filenamesToRead := make([]string, 0)
 	for _, e := range filenames {
-		newPath := path.Join(c.newPrefix, strings.Replace(e, c.rootPath, c.newPrefix, 1))
-		if !strings.Contains(newPath, "<microSD1>") && []rune(newPath)[0] != '/' {
-			newPath = "/" + newPath
-		}
+		newPath := c.DevicePath(e)
 
-		if t, exists := c.cache[newPath]; exists {
+		if t, exists := c.cache[newPath]; exists && !stale(e, t) {
+			// t is a copy, the cache keeps the tags as read from the file
+			if err := c.applyOverrides(e, &t); err != nil {
+				return []track.Track{}, err, notRead
+			}
 			tracks = append(tracks, t)
 			notRead++
 		} else {
@@ -75,8 +83,13 @@ func (c *Cache) Add(filenames ...string) ([]track.Track, error, int) {
 		}
 
 		for _, t := range tags {
-			t.Filename = newPaths[t.Filename]
+			filename := t.Filename
+			t.Filename = newPaths[filename]
 			c.cache[t.Filename] = t
+
+			if err := c.applyOverrides(filename, &t); err != nil {
+				return []track.Track{}, err, notRead
+			}
 			tracks = append(tracks, t)
 		}
 
@@ -85,9 +98,39 @@ func (c *Cache) Add(filenames ...string) ([]track.Track, error, int) {
 	return tracks, nil, notRead
 }
 
+// DevicePath returns the path the player sees filename at, relative to the root
+// of its volume and starting with the volume's name, or / for internal storage
+func (c *Cache) DevicePath(filename string) string {
+	rel, err := filepath.Rel(c.rootPath, filename)
+	if err != nil {
+		rel = filename
+	}
+
+	prefix := c.newPrefix
+	if prefix == "" {
+		prefix = "/"
+	}
+	return path.Join(prefix, filepath.ToSlash(rel))
+}
+
+// stale reports whether filename was modified after t was cached
+func stale(filename string, t track.Track) bool {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return true
+	}
+	return uint32(info.ModTime().Unix()) != t.Mtime
+}
+
+func (c *Cache) applyOverrides(filename string, t *track.Track) error {
+	if c.overrides == nil {
+		return nil
+	}
+	return c.overrides.Apply(filename, t)
+}
+
 func (c *Cache) Contains(key string) bool {
-	newPath := path.Join(c.newPrefix, strings.Replace(key, c.rootPath, "", 1))
-	_, e := c.cache[newPath]
+	_, e := c.cache[c.DevicePath(key)]
 	return e
 }
 
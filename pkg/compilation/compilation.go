@@ -0,0 +1,63 @@
+package compilation
+
+import (
+	"path"
+	"rbdbtools/pkg/track"
+)
+
+const DefaultVariousArtists = "Various Artists"
+
+type Options struct {
+	// VariousArtists is the album artist assigned to compilations
+	VariousArtists string
+	// MinArtists is the number of distinct artists an album in a single directory
+	// needs to be treated as a compilation, 0 disables directory detection
+	MinArtists int
+}
+
+// Detect assigns the various artists album artist to tracks of compilations that
+// have no album artist tag of their own, returning the number of tracks changed.
+// A track without an album artist tag is one whose album artist is its artist.
+func Detect(tracks []track.Track, opts Options) int {
+	if opts.VariousArtists == "" {
+		opts.VariousArtists = DefaultVariousArtists
+	}
+
+	compilations := make(map[string]bool)
+	if opts.MinArtists > 0 {
+		artists := make(map[string]map[string]bool)
+		for _, t := range tracks {
+			if t.AlbumArtist != t.Artist {
+				continue
+			}
+
+			k := albumKey(t)
+			if _, exists := artists[k]; !exists {
+				artists[k] = make(map[string]bool)
+			}
+			artists[k][t.Artist] = true
+		}
+
+		for k, v := range artists {
+			compilations[k] = len(v) >= opts.MinArtists
+		}
+	}
+
+	changed := 0
+	for i, t := range tracks {
+		if t.AlbumArtist != t.Artist || t.AlbumArtist == opts.VariousArtists {
+			continue
+		}
+
+		if t.Compilation || compilations[albumKey(t)] {
+			tracks[i].AlbumArtist = opts.VariousArtists
+			changed++
+		}
+	}
+
+	return changed
+}
+
+func albumKey(t track.Track) string {
+	return path.Dir(t.Filename) + "\x00" + t.Album
+}
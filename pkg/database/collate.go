@@ -0,0 +1,88 @@
+package database
+
+import (
+	"rbdbtools/pkg/track"
+	"strings"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+var DefaultArticles = []string{"The", "A", "An"}
+
+// Collation configures how tracks and tags are ordered
+type Collation struct {
+	// Language is a BCP 47 tag used for locale aware ordering, empty for the root collation
+	Language string
+	// Articles are leading words ignored when ordering artists, albums, titles and composers
+	Articles []string
+	// SortTags orders by ARTISTSORT, ALBUMSORT etc. when a track has them
+	SortTags bool
+	// UntaggedLast places <Untagged> after every other value instead of before
+	UntaggedLast bool
+}
+
+func DefaultCollation() Collation {
+	return Collation{
+		Articles: DefaultArticles,
+		SortTags: true,
+	}
+}
+
+type collator struct {
+	Collation
+	collator *collate.Collator
+}
+
+func newCollator(c Collation) (*collator, error) {
+	lang := language.Und
+	if c.Language != "" {
+		var err error
+		lang, err = language.Parse(c.Language)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &collator{
+		Collation: c,
+		collator:  collate.New(lang, collate.IgnoreCase, collate.Loose),
+	}, nil
+}
+
+// key returns the string s is ordered by, sortTag is its sort tag if any and
+// strip removes a leading article
+func (c *collator) key(s string, sortTag string, strip bool) string {
+	if s == track.Untagged {
+		return s
+	} else if c.SortTags && sortTag != "" && sortTag != track.Untagged {
+		return sortTag
+	} else if !strip {
+		return s
+	}
+
+	for _, a := range c.Articles {
+		if len(s) > len(a)+1 && strings.EqualFold(s[:len(a)], a) && s[len(a)] == ' ' {
+			return strings.TrimLeft(s[len(a)+1:], " ")
+		}
+	}
+	return s
+}
+
+// compare compares two keys, returning -1, 0 or 1
+func (c *collator) compare(a string, b string) int {
+	if a == b {
+		return 0
+	} else if a == track.Untagged || b == track.Untagged {
+		r := -1
+		if b == track.Untagged {
+			r = 1
+		}
+		if c.UntaggedLast {
+			r = -r
+		}
+		return r
+	}
+
+	return c.collator.CompareString(a, b)
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"rbdbtools/pkg/decoder"
+	"rbdbtools/pkg/tcformat"
+	"rbdbtools/pkg/track"
+	"rbdbtools/tools"
+	"time"
+)
+
+var ConversionMismatchError = errors.New("converted database does not match the original")
+
+// Convert decodes the database in dbPath and saves it to targetDir, which may
+// be dbPath, in the byte order given by bigEndian and in version. Every entry is
+// kept, deleted duplicates too, with its strings, order, statistics and flags. The converted database is written
+// to a staging directory and decoded again to check nothing was lost before it
+// is installed, so targetDir is left as it was if anything was.
+func Convert(dbPath string, targetDir string, bigEndian bool, version uint32) error {
+	// Mtimes are decoded and encoded in the same zone, so any zone keeps their wall clock time
+	decoded, err := decoder.DecodeDatabases(dbPath)
+	if err != nil {
+		return err
+	}
+	d, err := fromDecoded(decoded, time.UTC, true)
+	if err != nil {
+		return err
+	}
+
+	d.SetKeepOrder(true)
+	d.SetBigEndian(bigEndian)
+	err = d.SetFormatVersion(version)
+	if err != nil {
+		return err
+	}
+
+	// Mtimes only keep what the new version can store
+	expected := decoded.Tracks(decoder.TrackOptions{IncludeDeleted: true, TimeZone: time.UTC})
+	for i, t := range expected {
+		expected[i].Mtime = tcformat.DecodeMtime(tcformat.EncodeMtime(t.Mtime, time.UTC, version), time.UTC, version)
+		if t.CommitId == 0 {
+			expected[i].CommitId = d.State().CommitId
+		}
+	}
+
+	if !tools.DirExists(targetDir) {
+		return errors.New("target directory does not exist")
+	}
+	staging, err := ioutil.TempDir(targetDir, ".rbdbconvert")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	files := newCompiledFiles(&d)
+	for _, e := range files.names() {
+		err = writeSynced(path.Join(staging, e), func(w io.Writer) error {
+			return files.writeFile(e, w)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = verifyConversion(staging, expected, bigEndian, version)
+	if err != nil {
+		return err
+	}
+
+	_, err = install(targetDir, dirFiles{staging, files.names()}, d.backups)
+	return err
+}
+
+// verifyConversion decodes the database in dbPath, checking it holds the
+// expected entries in the same order
+func verifyConversion(dbPath string, expected []track.Track, bigEndian bool, version uint32) error {
+	decoded, err := decoder.DecodeDatabases(dbPath)
+	if err != nil {
+		return err
+	}
+
+	if decoded.BigEndian != bigEndian || decoded.Index.Magic != version {
+		return fmt.Errorf("%w: written as 0x%08X big endian %t", ConversionMismatchError, decoded.Index.Magic, decoded.BigEndian)
+	}
+
+	tracks := decoded.Tracks(decoder.TrackOptions{IncludeDeleted: true, TimeZone: time.UTC})
+	if len(tracks) != len(expected) {
+		return fmt.Errorf("%w: %d entries written, expected %d", ConversionMismatchError, len(tracks), len(expected))
+	}
+	for i, t := range tracks {
+		if t != expected[i] {
+			return fmt.Errorf("%w: entry %d, %s", ConversionMismatchError, i, t.Filename)
+		}
+	}
+
+	return nil
+}
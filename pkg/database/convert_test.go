@@ -0,0 +1,52 @@
+package database
+
+import (
+	"io/ioutil"
+	"os"
+	"rbdbtools/pkg/decoder"
+	"rbdbtools/pkg/tcformat"
+	"testing"
+	"time"
+)
+
+func TestConvertKeepsDeletedDuplicates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rbdbconvert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := New(false)
+	d.SetKeepOrder(true)
+	tracks := syntheticLibrary(3)
+	if err = d.Add(tracks...); err != nil {
+		t.Fatal(err)
+	}
+	// The player leaves the old entry of a file flagged deleted when it is added again
+	deleted := tracks[1]
+	deleted.Flags |= tcformat.FlagDeleted
+	d.index = append(d.index, deleted)
+	if _, err = d.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	err = Convert(dir, dir, true, tcformat.OldestMagic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decoder.DecodeDatabases(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.BigEndian || decoded.Index.Magic != tcformat.OldestMagic {
+		t.Errorf("converted to 0x%08X big endian %t", decoded.Index.Magic, decoded.BigEndian)
+	}
+	converted := decoded.Tracks(decoder.TrackOptions{IncludeDeleted: true, TimeZone: time.UTC})
+	if len(converted) != 4 {
+		t.Fatalf("converted %d entries, want 4", len(converted))
+	}
+	if c := converted[3]; c.Filename != deleted.Filename || c.Flags&tcformat.FlagDeleted == 0 {
+		t.Errorf("last entry is %s flags %d, want deleted %s", c.Filename, c.Flags, deleted.Filename)
+	}
+}
@@ -2,56 +2,279 @@ package database
 
 import (
 	"errors"
-	"io/ioutil"
-	"os"
-	"path"
+	"fmt"
+	"rbdbtools/pkg/tcformat"
 	"rbdbtools/pkg/track"
 	"rbdbtools/tools"
 	"sort"
-	"strings"
+	"time"
+)
+
+// Version is the tagcache format version written by default
+const Version = tcformat.Magic
+
+var (
+	DuplicateTrackError = errors.New("track is already in the database")
+	TrackNotFoundError  = errors.New("track is not in the database")
 )
 
 type Database struct {
 	index     []track.Track
-	files     map[string][]byte
+	positions map[string]int
+	upsert    bool
+	keepOrder bool
+	layout    *layout
 	modified  bool
 	bigEndian bool
+	collator  *collator
+	normalize Normalization
+	timeZone  *time.Location
+	state     State
+	backups   int
+	version   uint32
 }
 
+// DefaultBackups is how many backups of previous databases Save keeps unless set otherwise
+const DefaultBackups = 3
+
 func New(bigEndian bool) Database {
+	c, _ := newCollator(DefaultCollation())
 	return Database{
 		index:     make([]track.Track, 0),
+		positions: make(map[string]int),
 		bigEndian: bigEndian,
 		modified:  true,
+		collator:  c,
+		timeZone:  time.Local,
+		state:     NewState(),
+		version:   Version,
+		backups:   DefaultBackups,
+	}
+}
+
+func (d *Database) BigEndian() bool {
+	return d.bigEndian
+}
+
+// SetBigEndian sets the byte order the database is written in
+func (d *Database) SetBigEndian(bigEndian bool) {
+	d.bigEndian = bigEndian
+}
+
+func (d *Database) FormatVersion() uint32 {
+	return d.version
+}
+
+// SetFormatVersion sets the format version written, versions from
+// tcformat.OldestMagic only differ by how mtimes are stored
+func (d *Database) SetFormatVersion(version uint32) error {
+	if !tcformat.SupportedVersion(version) {
+		return fmt.Errorf("%w: 0x%08X", tcformat.UnsupportedVersionError, version)
 	}
+	d.version = version
+	return nil
 }
 
-func (d *Database) Add(tracks ...track.Track) {
+func (d *Database) State() State {
+	return d.state
+}
+
+func (d *Database) SetState(s State) {
 	d.modified = true
-	d.index = append(d.index, tracks...)
+	d.state = s
 }
 
-func (d *Database) Save(targetDir string) error {
-	if targetDir == "" {
-		return errors.New("target must be specified")
-	} else if !tools.DirExists(targetDir) {
-		return errors.New("target directory does not exist")
+// SetTimeZone sets the time zone of the player's clock, used to encode mtimes
+func (d *Database) SetTimeZone(loc *time.Location) {
+	d.modified = true
+	d.timeZone = loc
+}
+
+func (d *Database) SetNormalization(n Normalization) {
+	d.modified = true
+	d.normalize = n
+	d.reindex()
+}
+
+func (d *Database) SetCollation(collation Collation) error {
+	c, err := newCollator(collation)
+	if err != nil {
+		return err
+	}
+
+	d.modified = true
+	d.collator = c
+	return nil
+}
+
+// SetKeepOrder sets whether the index keeps the order tracks were added in
+// instead of being sorted by the collation
+func (d *Database) SetKeepOrder(keep bool) {
+	d.modified = true
+	d.keepOrder = keep
+}
+
+// SetUpsert sets whether Add replaces tracks with the same filename instead of
+// rejecting them
+func (d *Database) SetUpsert(upsert bool) {
+	d.upsert = upsert
+}
+
+// Add adds tracks to the database, tracks are keyed by filename. If a filename is
+// already in the database, or is given twice, nothing is added and
+// DuplicateTrackError is returned unless upsert is set, in which case the last
+// track with a filename wins. Adding the filename of a deleted track resurrects it.
+func (d *Database) Add(tracks ...track.Track) error {
+	if !d.upsert {
+		seen := make(map[string]bool)
+		for _, t := range tracks {
+			k := d.key(t.Filename)
+			if i, exists := d.positions[k]; (exists && d.index[i].Flags&tcformat.FlagDeleted == 0) || seen[k] {
+				return fmt.Errorf("%w: %s", DuplicateTrackError, t.Filename)
+			}
+			seen[k] = true
+		}
+	}
+
+	d.modified = true
+	for _, t := range tracks {
+		if i, exists := d.positions[d.key(t.Filename)]; exists {
+			if old := d.index[i]; old.Flags&tcformat.FlagDeleted != 0 {
+				resurrect(&t, old)
+			}
+			d.index[i] = t
+		} else {
+			d.positions[d.key(t.Filename)] = len(d.index)
+			d.index = append(d.index, t)
+		}
+	}
+	return nil
+}
+
+func (d *Database) Get(filename string) (track.Track, bool) {
+	i, exists := d.positions[d.key(filename)]
+	if !exists {
+		return track.Track{}, false
+	}
+	return d.index[i], true
+}
+
+// Update replaces the track with the same filename as t
+func (d *Database) Update(t track.Track) error {
+	i, exists := d.positions[d.key(t.Filename)]
+	if !exists {
+		return fmt.Errorf("%w: %s", TrackNotFoundError, t.Filename)
+	}
+
+	d.modified = true
+	d.index[i] = t
+	return nil
+}
+
+// Delete marks the track deleted, keeping its entry and statistics in the
+// database until it is compacted
+func (d *Database) Delete(filename string) error {
+	i, exists := d.positions[d.key(filename)]
+	if !exists {
+		return fmt.Errorf("%w: %s", TrackNotFoundError, filename)
 	}
 
-	for k, v := range d.compile() {
-		err := ioutil.WriteFile(path.Join(targetDir, k), v, os.ModePerm)
-		if err != nil {
-			return err
+	d.modified = true
+	d.index[i].Flags |= tcformat.FlagDeleted
+	return nil
+}
+
+// Compact removes deleted tracks, returning how many were removed. The
+// remaining tracks keep their order.
+func (d *Database) Compact() int {
+	live := d.index[:0]
+	for _, e := range d.index {
+		if e.Flags&tcformat.FlagDeleted == 0 {
+			live = append(live, e)
 		}
 	}
 
+	removed := len(d.index) - len(live)
+	if removed > 0 {
+		d.modified = true
+		d.index = live
+		d.reindex()
+	}
+	return removed
+}
+
+// key is what a filename is looked up by, filenames are written normalised so
+// ones that only differ by normalisation are the same file on the player
+func (d *Database) key(filename string) string {
+	return d.normalize.normalize(filename)
+}
+
+// reindex rebuilds the positions of tracks by their key
+func (d *Database) reindex() {
+	d.positions = make(map[string]int, len(d.index))
+	for i, e := range d.index {
+		d.positions[d.key(e.Filename)] = i
+	}
+}
+
+// resurrect restores the statistics of a deleted track to t, which replaces it
+func resurrect(t *track.Track, deleted track.Track) {
+	t.PlayCount = deleted.PlayCount
+	t.Rating = deleted.Rating
+	t.PlayTime = deleted.PlayTime
+	t.LastPlayed = deleted.LastPlayed
+	t.LastElapsed = deleted.LastElapsed
+	t.LastOffset = deleted.LastOffset
+	t.CommitId = deleted.CommitId
+	t.Flags = (t.Flags &^ tcformat.FlagDeleted) | tcformat.FlagResurrected
+}
+
+func (d *Database) Remove(filename string) error {
+	i, exists := d.positions[d.key(filename)]
+	if !exists {
+		return fmt.Errorf("%w: %s", TrackNotFoundError, filename)
+	}
+
+	// Order doesn't matter until compiling, so move the last track into the gap
+	d.modified = true
+	last := len(d.index) - 1
+	d.index[i] = d.index[last]
+	d.positions[d.key(d.index[i].Filename)] = i
+	d.index = d.index[:last]
+	delete(d.positions, d.key(filename))
 	return nil
 }
 
+// Save installs the database into targetDir, only files that differ from the
+// ones already there are written. The names of the files written are returned.
+func (d *Database) Save(targetDir string) ([]string, error) {
+	return d.Install(targetDir, nil)
+}
+
+// Install is Save that also moves the named files beside the database into the
+// backup of the one it replaces, such as ones the player keeps that only apply
+// to that database
+func (d *Database) Install(targetDir string, retire []string) ([]string, error) {
+	if targetDir == "" {
+		return nil, errors.New("target must be specified")
+	} else if !tools.DirExists(targetDir) {
+		return nil, errors.New("target directory does not exist")
+	}
+
+	return install(targetDir, newCompiledFiles(d), d.backups, retire...)
+}
+
+// SetBackups sets how many backups of previous databases Save keeps, a negative
+// number keeps every backup and 0 keeps none. DefaultBackups are kept until set.
+func (d *Database) SetBackups(n int) {
+	d.backups = n
+}
+
 func (d *Database) Size() int {
 	size := 0
-	for _, v := range d.compile() {
-		size += len(v)
+	for _, e := range Files() {
+		n, _ := d.FileSize(e)
+		size += n
 	}
 	return size
 }
@@ -69,33 +292,20 @@ type HeaderData struct {
 func (d *Database) Entries() map[string]Stats {
 	counts := make(map[string]Stats)
 
-	for k, v := range d.compile() {
+	for _, k := range Files() {
+		entries, headerSize, _ := d.fileHeader(k)
+		size, _ := d.FileSize(k)
 		s := Stats{
-			Size: len(v),
+			Size: size,
 			Header: HeaderData{
-				Size:    int(tools.BytesNum(v[4:8], d.bigEndian)),
-				Entries: int(tools.BytesNum(v[8:12], d.bigEndian)),
+				Size:    headerSize,
+				Entries: entries,
 			},
 		}
 
-		switch k {
-		case "database_0.tcd":
-			counts["artists"] = s
-		case "database_1.tcd":
-			counts["albums"] = s
-		case "database_2.tcd":
-			counts["genres"] = s
-		case "database_3.tcd":
-			counts["tracks"] = s
-		case "database_5.tcd":
-			counts["composers"] = s
-		case "database_6.tcd":
-			counts["comments"] = s
-		case "database_7.tcd":
-			counts["album artists"] = s
-		case "database_8.tcd":
-			counts["groupings"] = s
-		case "database_idx.tcd":
+		if t, ok := tcformat.TagOfFilename(k); ok {
+			counts[t.String()+"s"] = s
+		} else {
 			counts["index"] = s
 		}
 	}
@@ -108,44 +318,33 @@ func (d *Database) Tracks() []track.Track {
 }
 
 func (d *Database) sort() {
-	ut := "<Untagged>"
-	sort.Slice(d.index, func(i, j int) bool {
-		s1 := strings.ToLower(d.index[i].Artist)
-		if s1 == ut {
-			return true
+	c := d.collator
+	sort.SliceStable(d.index, func(i, j int) bool {
+		t1, t2 := &d.index[i], &d.index[j]
+
+		// By artist
+		if r := c.compare(c.key(t1.Artist, t1.ArtistSort, true), c.key(t2.Artist, t2.ArtistSort, true)); r != 0 {
+			return r < 0
 		}
-		s2 := strings.ToLower(d.index[j].Artist)
-
-		if s1 == s2 {
-			i1 := d.index[i].Year
-			i2 := d.index[j].Year
-
-			if i1 == i2 {
-				s1 = strings.ToLower(d.index[i].Album)
-				if s1 == ut {
-					return true
-				}
-				s2 = strings.ToLower(d.index[j].Album)
-
-				if s1 == s2 {
-					i1 = d.index[i].Track
-					i2 = d.index[j].Track
-
-					if i1 == i2 {
-						s1 = strings.ToLower(d.index[i].Title)
-						if s1 == ut {
-							return true
-						}
-						s2 = strings.ToLower(d.index[j].Title)
-
-						return s1 < s2 // By track name
-					}
-					return i1 < i2 // By track number
-				}
-				return s1 < s2 // By album
-			}
-			return i1 < i2 // By year
+		// By year
+		if t1.Year != t2.Year {
+			return t1.Year < t2.Year
+		}
+		// By album
+		if r := c.compare(c.key(t1.Album, t1.AlbumSort, true), c.key(t2.Album, t2.AlbumSort, true)); r != 0 {
+			return r < 0
+		}
+		// By track number
+		if t1.Track != t2.Track {
+			return t1.Track < t2.Track
 		}
-		return s1 < s2 // By artist
+		// By track name
+		if r := c.compare(c.key(t1.Title, t1.TitleSort, true), c.key(t2.Title, t2.TitleSort, true)); r != 0 {
+			return r < 0
+		}
+		// By filename, which is unique, so the order never depends on the order tracks were added
+		return t1.Filename < t2.Filename
 	})
+
+	d.reindex()
 }
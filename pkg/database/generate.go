@@ -1,261 +1,246 @@
 package database
 
 import (
-	"rbdbtools/tools"
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"rbdbtools/pkg/tcformat"
 	"sort"
-	"strings"
 )
 
-const (
-	artist = iota
-	album
-	genre
-	title
-	filename
-	composer
-	comment
-	albumartist
-	grouping
-	year
-	discnumber
-	tracknumber
-	bitrate
-	length
-	playcount
-	rating
-	playtime
-	lastplayed
-	commitid
-	mtime
-	lastelapsed
-	lastoffset
-	tagCount
+// bufferSize is the size of the buffer files are written through
+const bufferSize = 64 * 1024
 
-	dbVer = 0x5443480F
-)
-
-type tagEntry struct {
-	offset  int
-	tag     string
-	length  [4]byte
-	id      [4]byte
-	data    []byte
-	padding []byte
+// Fields that ignore leading articles when ordering
+var stripArticles = [tcformat.StringTagCount]bool{
+	tcformat.Artist:      true,
+	tcformat.Album:       true,
+	tcformat.Title:       true,
+	tcformat.Composer:    true,
+	tcformat.AlbumArtist: true,
 }
 
-type indexEntry struct {
-	index       int32
-	tags        [tagCount][4]byte
-	flag        [4]byte
-	tagPointers [grouping + 1]*tagEntry
-}
-
-type header struct {
-	version  [4]byte
-	size     [4]byte
-	entries  [4]byte
-	elements []tagEntry
+type tagEntry struct {
+	tcformat.TagEntry
+	sort   string
+	offset uint32
 }
 
-type masterHeader struct {
-	header   header
-	serial   [4]byte
-	commitId [4]byte
-	dirty    [4]byte
-	elements []indexEntry
+// layout is where everything goes in the database files, it only holds each
+// unique string once and the offsets of each track's strings, so files can be
+// written straight to a writer without building them in memory
+type layout struct {
+	tags    [tcformat.StringTagCount][]*tagEntry
+	sizes   [tcformat.StringTagCount]int
+	offsets [][tcformat.StringTagCount]uint32
 }
 
-func (d *Database) compile() map[string][]byte {
-	// No need to compile, return
-	if !d.modified {
-		return d.files
+func (d *Database) plan() *layout {
+	// No need to plan again, return
+	if !d.modified && d.layout != nil {
+		return d.layout
 	}
 
-	// Temp database
-	tags := make(map[int]map[string]*tagEntry)
-	titles := make([]*tagEntry, 0)
-	filenames := make([]*tagEntry, 0)
-
-	// Initialize sets for each type of tag
-	for i := 0; i <= grouping; i++ {
-		tags[i] = make(map[string]*tagEntry)
+	// Sort tracks before inserting into index
+	if !d.keepOrder {
+		d.sort()
 	}
 
-	// Initialize headers
-	index := masterHeader{
-		elements: make([]indexEntry, 0),
+	l := &layout{
+		offsets: make([][tcformat.StringTagCount]uint32, len(d.index)),
 	}
-	copy(index.header.version[:], tools.NumBytes(dbVer, d.bigEndian))
-	copy(index.commitId[:], tools.NumBytes(1, d.bigEndian))
 
-	headers := make([]header, grouping+1)
-	for i := range headers {
-		copy(headers[i].version[:], tools.NumBytes(dbVer, d.bigEndian))
+	// Unique tags of each type, titles and filenames are never shared so they aren't in here
+	unique := [tcformat.StringTagCount]map[string]*tagEntry{}
+	for k := range unique {
+		unique[k] = make(map[string]*tagEntry)
 	}
+	refs := make([][tcformat.StringTagCount]*tagEntry, len(d.index))
 
-	// Sort tracks before inserting into index
-	d.sort()
-
-	// Add tracks to index
+	n := d.normalize.normalize
 	for i, e := range d.index {
-		// Initialize index entry
-		ie := indexEntry{
-			index: int32(i),
+		// Get string fields from track
+		// Strings are normalised so the same tag is only added once
+		fields := [tcformat.StringTagCount]string{
+			tcformat.Artist:      n(e.Artist),
+			tcformat.Album:       n(e.Album),
+			tcformat.Genre:       n(e.Genre),
+			tcformat.Title:       n(e.Title),
+			tcformat.Filename:    n(e.Filename),
+			tcformat.Composer:    n(e.Composer),
+			tcformat.Comment:     n(e.Comment),
+			tcformat.AlbumArtist: n(e.AlbumArtist),
+			tcformat.Grouping:    n(e.Grouping),
 		}
 
-		// Get string fields from track
-		fields := map[int]string{
-			artist:      e.Artist,
-			album:       e.Album,
-			genre:       e.Genre,
-			title:       e.Title,
-			filename:    e.Filename,
-			composer:    e.Composer,
-			comment:     e.Comment,
-			albumartist: e.AlbumArtist,
-			grouping:    e.Grouping,
+		// Sort tags of string fields that have them
+		sortTags := [tcformat.StringTagCount]string{
+			tcformat.Artist:      n(e.ArtistSort),
+			tcformat.Album:       n(e.AlbumSort),
+			tcformat.Title:       n(e.TitleSort),
+			tcformat.Composer:    n(e.ComposerSort),
+			tcformat.AlbumArtist: n(e.AlbumArtistSort),
 		}
 
 		for k, v := range fields {
-			if entry, exists := tags[k][v]; exists {
-				// Add pointer field pointer to index entry if already has been added
-				// Title and Filename should never exists
-				ie.tagPointers[k] = entry
-			} else {
-				// Get padding size
-				l := 0
-				if !(k == title || k == filename) {
-					l = (len(v) + 1) % 8
-					if l != 0 {
-						l = 8 - l
-					}
-				}
-				// Add tag entry to index
-				ie.tagPointers[k] = &tagEntry{
-					tag:     v,
-					data:    []byte(v),
-					padding: []byte("XXXXXXXXX")[:l],
-				}
-				copy(ie.tagPointers[k].length[:], tools.NumBytes(uint32(len(v)+l+1), d.bigEndian))
-
-				// Add tag to appropriate database
-				// Title and filename use their own lists, since their entries are not unique
-				if k == title {
-					titles = append(titles, ie.tagPointers[k])
-				} else if k == filename {
-					filenames = append(filenames, ie.tagPointers[k])
-				} else {
-					tags[k][v] = ie.tagPointers[k]
-				}
+			if entry, exists := unique[k][v]; exists {
+				refs[i][k] = entry
+				continue
+			}
 
-				if k == title || k == filename {
-					// For title and filename entries, add index to entry
-					copy(ie.tagPointers[k].id[:], tools.NumBytes(uint32(i), d.bigEndian))
-				} else {
-					// Otherwise set index to 0xFFFFFFFF
-					copy(ie.tagPointers[k].id[:], tools.MaxBytes())
-				}
+			tag := tcformat.Tag(k)
+			entry := &tagEntry{
+				TagEntry: tcformat.NewTagEntry(v, tcformat.UniqueIdx, tag),
+				sort:     d.collator.key(v, sortTags[k], stripArticles[k]),
+			}
 
-				copy(ie.tags[year][:], tools.NumBytes(e.Year, d.bigEndian))
-				copy(ie.tags[discnumber][:], tools.NumBytes(e.Disc, d.bigEndian))
-				copy(ie.tags[tracknumber][:], tools.NumBytes(e.Track, d.bigEndian))
-				copy(ie.tags[bitrate][:], tools.NumBytes(e.Bitrate, d.bigEndian))
-				copy(ie.tags[length][:], tools.NumBytes(e.Length, d.bigEndian))
-				copy(ie.tags[mtime][:], tools.NumBytes(e.Mtime, d.bigEndian))
+			if tag.Unique() {
+				// Titles and filenames hold the index of their track
+				entry.Idx = uint32(i)
+			} else {
+				unique[k][v] = entry
 			}
-		}
 
-		// Add to index
-		index.elements = append(index.elements, ie)
+			refs[i][k] = entry
+			l.tags[k] = append(l.tags[k], entry)
+		}
 	}
 
-	// Set index header infos
-	copy(index.header.entries[:], tools.NumBytes(uint32(len(index.elements)), d.bigEndian))
-	copy(index.header.size[:], tools.NumBytes(uint32((len(index.elements)*(tagCount+1)*4)+12), d.bigEndian))
-
-	// Create individual databases
-	for k1, v1 := range tags {
-		var db []*tagEntry
-
-		// Assign list to correct entry, else create a list and add unique entries
-		if k1 == title {
-			db = titles
-		} else if k1 == filename {
-			db = filenames
-		} else {
-			db = make([]*tagEntry, 0)
-			for _, v2 := range v1 {
-				db = append(db, v2)
+	for k, db := range l.tags {
+		// Sort the databases, ties are broken by the tag itself so the order never
+		// depends on map iteration. Titles and filenames with the same tag stay in
+		// index order.
+		sort.SliceStable(db, func(i, j int) bool {
+			if r := d.collator.compare(db[i].sort, db[j].sort); r != 0 {
+				return r < 0
 			}
-		}
-
-		// Sort the databases
-		sort.Slice(db, func(i, j int) bool {
-			return strings.ToLower(db[i].tag) < strings.ToLower(db[j].tag)
+			return db[i].Data < db[j].Data
 		})
 
-		// Assign offsets and add tags to databases
-		offset := 12
+		// Assign offsets
+		offset := tcformat.HeaderSize
 		for _, e := range db {
-			e.offset = offset
-			headers[k1].elements = append(headers[k1].elements, *e)
-
-			offset += 8 + len(e.data) + 1 + len(e.padding)
+			e.offset = uint32(offset)
+			offset += e.Size()
 		}
-
-		copy(headers[k1].entries[:], tools.NumBytes(uint32(len(db)), d.bigEndian))
-		copy(headers[k1].size[:], tools.NumBytes(uint32(offset-12), d.bigEndian))
+		l.sizes[k] = offset - tcformat.HeaderSize
 	}
 
 	// Add offsets to index
-	for i := range index.elements {
-		for j := 0; j <= grouping; j++ {
-			copy(index.elements[i].tags[j][:], tools.NumBytes(uint32(index.elements[i].tagPointers[j].offset), d.bigEndian))
+	for i, e := range refs {
+		for k, entry := range e {
+			l.offsets[i][k] = entry.offset
 		}
 	}
 
-	// Generate databases
-	tagToFilename := map[int]string{
-		artist:      "database_0.tcd",
-		album:       "database_1.tcd",
-		genre:       "database_2.tcd",
-		title:       "database_3.tcd",
-		filename:    "database_4.tcd",
-		composer:    "database_5.tcd",
-		comment:     "database_6.tcd",
-		albumartist: "database_7.tcd",
-		grouping:    "database_8.tcd",
+	d.layout = l
+	d.modified = false
+	return l
+}
+
+// Files returns the names of the files a database is made of
+func Files() []string {
+	return tcformat.Filenames()
+}
+
+// FileSize returns the size of the named database file without writing it
+func (d *Database) FileSize(name string) (int, error) {
+	entries, size, err := d.fileHeader(name)
+	if err != nil {
+		return 0, err
 	}
-	d.files = make(map[string][]byte)
 
-	for k, v := range tagToFilename {
-		d.files[v] = headers[k].version[:]
-		d.files[v] = append(d.files[v], headers[k].size[:]...)
-		d.files[v] = append(d.files[v], headers[k].entries[:]...)
-		for _, e := range headers[k].elements {
-			d.files[v] = append(d.files[v], e.length[:]...)
-			d.files[v] = append(d.files[v], e.id[:]...)
-			d.files[v] = append(d.files[v], e.data...)
-			d.files[v] = append(d.files[v], 0x00)
-			d.files[v] = append(d.files[v], e.padding...)
-		}
+	if name == tcformat.IndexFilename {
+		return tcformat.IndexHeaderSize + entries*tcformat.IndexEntrySize, nil
 	}
+	return tcformat.HeaderSize + size, nil
+}
 
-	// Generate index
-	idx := "database_idx.tcd"
-	d.files[idx] = index.header.version[:]
-	d.files[idx] = append(d.files[idx], index.header.size[:]...)
-	d.files[idx] = append(d.files[idx], index.header.entries[:]...)
-	d.files[idx] = append(d.files[idx], index.serial[:]...)
-	d.files[idx] = append(d.files[idx], index.commitId[:]...)
-	d.files[idx] = append(d.files[idx], index.dirty[:]...)
-	for _, e := range index.elements {
-		for _, t := range e.tags {
-			d.files[idx] = append(d.files[idx], t[:]...)
-		}
-		d.files[idx] = append(d.files[idx], e.flag[:]...)
+// fileHeader returns the number of entries and size recorded in the header of the named file
+func (d *Database) fileHeader(name string) (int, int, error) {
+	l := d.plan()
+	if name == tcformat.IndexFilename {
+		return len(l.offsets), len(l.offsets)*tcformat.IndexEntrySize + tcformat.HeaderSize, nil
+	} else if k, ok := tcformat.TagOfFilename(name); ok {
+		return len(l.tags[k]), l.sizes[k], nil
 	}
+	return 0, 0, fmt.Errorf("%s is not a database file", name)
+}
 
-	d.modified = false
-	return d.files
+// WriteFile writes the named database file to w
+func (d *Database) WriteFile(name string, w io.Writer) error {
+	entries, size, err := d.fileHeader(name)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriterSize(w, bufferSize)
+	order := tcformat.ByteOrder(d.bigEndian)
+
+	header := tcformat.Header{
+		Magic:   d.version,
+		Size:    uint32(size),
+		Entries: uint32(entries),
+	}
+	if name == tcformat.IndexFilename {
+		var b [tcformat.IndexHeaderSize]byte
+		tcformat.IndexHeader{
+			Header:   header,
+			Serial:   d.state.Serial,
+			CommitId: d.state.CommitId,
+			Dirty:    d.state.Dirty,
+		}.Put(b[:], order)
+		_, _ = bw.Write(b[:])
+		d.writeIndex(bw, order)
+	} else {
+		var b [tcformat.HeaderSize]byte
+		header.Put(b[:], order)
+		_, _ = bw.Write(b[:])
+		k, _ := tcformat.TagOfFilename(name)
+		d.writeTags(bw, order, k)
+	}
+
+	// Errors writing to a bufio.Writer stick, so checking on flush is enough
+	return bw.Flush()
+}
+
+func (d *Database) writeTags(w *bufio.Writer, order binary.ByteOrder, k tcformat.Tag) {
+	for _, e := range d.plan().tags[k] {
+		_ = e.Write(w, order)
+	}
+}
+
+func (d *Database) writeIndex(w *bufio.Writer, order binary.ByteOrder) {
+	var b [tcformat.IndexEntrySize]byte
+	for i, e := range d.index {
+		entry := tcformat.IndexEntry{
+			Flags: e.Flags,
+		}
+		for k, offset := range d.layout.offsets[i] {
+			entry.Tags[k] = offset
+		}
+
+		// Numeric tags and statistics
+		entry.Tags[tcformat.Year] = e.Year
+		entry.Tags[tcformat.DiscNumber] = e.Disc
+		entry.Tags[tcformat.TrackNumber] = e.Track
+		entry.Tags[tcformat.Bitrate] = e.Bitrate
+		entry.Tags[tcformat.Length] = e.Length
+		entry.Tags[tcformat.PlayCount] = e.PlayCount
+		entry.Tags[tcformat.Rating] = e.Rating
+		entry.Tags[tcformat.PlayTime] = e.PlayTime
+		entry.Tags[tcformat.LastPlayed] = e.LastPlayed
+		entry.Tags[tcformat.CommitId] = e.CommitId
+		if e.CommitId == 0 {
+			// Added since the database was last saved
+			entry.Tags[tcformat.CommitId] = d.state.CommitId
+		}
+		entry.Tags[tcformat.Mtime] = tcformat.EncodeMtime(e.Mtime, d.timeZone, d.version)
+		entry.Tags[tcformat.LastElapsed] = e.LastElapsed
+		entry.Tags[tcformat.LastOffset] = e.LastOffset
+
+		entry.Put(b[:], order)
+		_, _ = w.Write(b[:])
+	}
 }
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"fmt"
+	"io/ioutil"
+	"rbdbtools/pkg/track"
+	"testing"
+	"time"
+)
+
+var librarySizes = []int{10000, 100000, 1000000}
+
+// syntheticLibrary makes n tracks sharing artists, albums and genres the way a
+// real library does, with unique titles and filenames
+func syntheticLibrary(n int) []track.Track {
+	tracks := make([]track.Track, n)
+	for i := range tracks {
+		album, artist := i/12, i/120
+		tracks[i] = track.Track{
+			Artist:      fmt.Sprintf("Artist %d", artist),
+			Album:       fmt.Sprintf("Album %d", album),
+			Genre:       fmt.Sprintf("Genre %d", artist%40),
+			Title:       fmt.Sprintf("Title %d", i),
+			Filename:    fmt.Sprintf("/Music/Artist %d/Album %d/%02d Title %d.mp3", artist, album, i%12+1, i),
+			Composer:    fmt.Sprintf("Composer %d", artist%500),
+			Comment:     track.Untagged,
+			AlbumArtist: fmt.Sprintf("Artist %d", artist),
+			Grouping:    track.Untagged,
+			Year:        uint32(1960 + album%60),
+			Track:       uint32(i%12 + 1),
+			Bitrate:     320,
+			Length:      uint32(180000 + i%120000),
+			Mtime:       uint32(1500000000 + i),
+		}
+	}
+	return tracks
+}
+
+func reportThroughput(b *testing.B, tracks int, start time.Time) {
+	b.ReportMetric(float64(tracks)*float64(b.N)/time.Since(start).Seconds(), "tracks/s")
+}
+
+// BenchmarkGenerate measures adding a library to a database and writing every file of it
+func BenchmarkGenerate(b *testing.B) {
+	for _, n := range librarySizes {
+		tracks := syntheticLibrary(n)
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				d := New(false)
+				if err := d.Add(tracks...); err != nil {
+					b.Fatal(err)
+				}
+				for _, e := range Files() {
+					if err := d.WriteFile(e, ioutil.Discard); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			reportThroughput(b, n, start)
+		})
+	}
+}
+
+// BenchmarkPlan measures laying out the files of a database, without writing them
+func BenchmarkPlan(b *testing.B) {
+	for _, n := range librarySizes {
+		d := New(false)
+		if err := d.Add(syntheticLibrary(n)...); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				d.modified = true
+				d.plan()
+			}
+			reportThroughput(b, n, start)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"rbdbtools/pkg/decoder"
+	"rbdbtools/pkg/tcformat"
+	"time"
+)
+
+// Load decodes the database in dbPath, keeping its endianness, version, state
+// and the statistics and flags of every entry. Strings are kept exactly as they
+// are, set a normalization to change them. timeZone is the time zone of the
+// player's clock the mtimes were encoded in. When a filename has more than one
+// entry, deleted ones are dropped in favour of a live one.
+func Load(dbPath string, timeZone *time.Location) (Database, error) {
+	decoded, err := decoder.DecodeDatabases(dbPath)
+	if err != nil {
+		return Database{}, err
+	}
+	return fromDecoded(decoded, timeZone, false)
+}
+
+// fromDecoded builds a database from decoded. With keepDuplicates every entry
+// is kept, even those sharing a filename.
+func fromDecoded(decoded *decoder.DecodedDatabases, timeZone *time.Location, keepDuplicates bool) (Database, error) {
+	d := New(decoded.BigEndian)
+	err := d.SetFormatVersion(decoded.Index.Magic)
+	if err != nil {
+		return Database{}, err
+	}
+	d.SetTimeZone(timeZone)
+	d.SetNormalization(NoNormalization)
+	d.SetState(State{
+		Serial:   decoded.Index.Serial,
+		CommitId: decoded.Index.CommitId,
+		Dirty:    decoded.Index.Dirty,
+	})
+
+	for _, t := range decoded.Tracks(decoder.TrackOptions{IncludeDeleted: true, TimeZone: timeZone}) {
+		if i, exists := d.positions[d.key(t.Filename)]; exists && !keepDuplicates {
+			if t.Flags&tcformat.FlagDeleted != 0 || d.index[i].Flags&tcformat.FlagDeleted == 0 {
+				continue
+			}
+			d.index[i] = t
+		} else {
+			d.positions[d.key(t.Filename)] = len(d.index)
+			d.index = append(d.index, t)
+		}
+	}
+
+	return d, nil
+}
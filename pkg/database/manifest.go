@@ -0,0 +1,123 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+)
+
+// ManifestName is the file saved alongside a database listing its files
+const ManifestName = "database_manifest.json"
+
+// Manifest is the size and SHA-256 of each file of a database. Identical tracks
+// and settings always compile to the same files, so comparing manifests tells
+// whether a database is out of date.
+type Manifest struct {
+	Files map[string]ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func newManifest(files fileSet) (Manifest, error) {
+	m := Manifest{
+		Files: make(map[string]ManifestFile),
+	}
+	for _, e := range files.names() {
+		sum, err := sumOf(files, e)
+		if err != nil {
+			return Manifest{}, err
+		}
+		m.Files[e] = sum
+	}
+	return m, nil
+}
+
+func (d *Database) Manifest() (Manifest, error) {
+	return newManifest(databaseOnly{newCompiledFiles(d)})
+}
+
+// ReadManifest reads the manifest saved with the database in dbPath
+func ReadManifest(dbPath string) (Manifest, error) {
+	data, err := ioutil.ReadFile(path.Join(dbPath, ManifestName))
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	m := Manifest{}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// Diff returns the files that are missing from, or differ in, other
+func (m Manifest) Diff(other Manifest) []string {
+	diff := make([]string, 0)
+	for k, v := range m.Files {
+		if o, exists := other.Files[k]; !exists || o != v {
+			diff = append(diff, k)
+		}
+	}
+	for k := range other.Files {
+		if _, exists := m.Files[k]; !exists {
+			diff = append(diff, k)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+// Verify hashes the database files in dbPath, returning those that don't match the manifest
+func (m Manifest) Verify(dbPath string) ([]string, error) {
+	existing := Manifest{
+		Files: make(map[string]ManifestFile),
+	}
+	for k := range m.Files {
+		size, sum, err := hashFile(path.Join(dbPath, k))
+		if err != nil {
+			return nil, err
+		}
+		existing.Files[k] = ManifestFile{
+			Size:   size,
+			SHA256: sum,
+		}
+	}
+	return m.Diff(existing), nil
+}
+
+// hashFile returns the size and hex encoded SHA-256 of filename
+func hashFile(filename string) (int, string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return int(n), hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+func (m Manifest) marshal() ([]byte, error) {
+	return json.MarshalIndent(m, "", "    ")
+}
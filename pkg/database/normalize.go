@@ -0,0 +1,41 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization is the unicode normalisation form tag strings are converted to
+type Normalization int
+
+const (
+	NFC Normalization = iota
+	NFD
+	NoNormalization
+)
+
+func ParseNormalization(s string) (Normalization, error) {
+	switch strings.ToLower(s) {
+	case "nfc":
+		return NFC, nil
+	case "nfd":
+		return NFD, nil
+	case "none":
+		return NoNormalization, nil
+	default:
+		return NFC, fmt.Errorf("unknown normalization %q", s)
+	}
+}
+
+func (n Normalization) normalize(s string) string {
+	switch n {
+	case NFC:
+		return norm.NFC.String(s)
+	case NFD:
+		return norm.NFD.String(s)
+	default:
+		return s
+	}
+}
@@ -0,0 +1,470 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// BackupDirName is the directory in a database directory holding backups of previous databases
+	BackupDirName  = ".rbdbbackups"
+	stagingDirName = ".rbdbstaging"
+	backupFormat   = "2006-01-02_15-04-05.000"
+)
+
+// fileSet is a set of files that can be written one at a time, so they never
+// all need to be in memory
+type fileSet interface {
+	names() []string
+	sum(name string) (ManifestFile, error)
+	writeFile(name string, w io.Writer) error
+}
+
+// sumOf hashes the named file of files by writing it
+func sumOf(files fileSet, name string) (ManifestFile, error) {
+	h := sha256.New()
+	c := &countingWriter{w: h}
+	err := files.writeFile(name, c)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	return ManifestFile{Size: c.n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+type memoryFiles map[string][]byte
+
+func (m memoryFiles) names() []string {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m memoryFiles) sum(name string) (ManifestFile, error) {
+	return sumOf(m, name)
+}
+
+func (m memoryFiles) writeFile(name string, w io.Writer) error {
+	_, err := w.Write(m[name])
+	return err
+}
+
+// dirFiles is a set of files already written to a directory
+type dirFiles struct {
+	dir   string
+	files []string
+}
+
+func (d dirFiles) names() []string {
+	return d.files
+}
+
+func (d dirFiles) sum(name string) (ManifestFile, error) {
+	size, sum, err := hashFile(path.Join(d.dir, name))
+	return ManifestFile{Size: size, SHA256: sum}, err
+}
+
+func (d dirFiles) writeFile(name string, w io.Writer) error {
+	f, err := os.Open(path.Join(d.dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// compiledFiles is a database's files along with its manifest. The manifest is
+// computed once, on first use, and shared by copies.
+type compiledFiles struct {
+	d        *Database
+	compiled *compiledManifest
+}
+
+type compiledManifest struct {
+	manifest Manifest
+	data     []byte
+	sum      ManifestFile
+}
+
+func newCompiledFiles(d *Database) compiledFiles {
+	return compiledFiles{d: d, compiled: &compiledManifest{}}
+}
+
+func (c compiledFiles) names() []string {
+	return append(Files(), ManifestName)
+}
+
+func (c compiledFiles) sum(name string) (ManifestFile, error) {
+	compiled, err := c.manifest()
+	if err != nil {
+		return ManifestFile{}, err
+	} else if name == ManifestName {
+		return compiled.sum, nil
+	}
+	return compiled.manifest.Files[name], nil
+}
+
+func (c compiledFiles) writeFile(name string, w io.Writer) error {
+	if name != ManifestName {
+		return c.d.WriteFile(name, w)
+	}
+
+	compiled, err := c.manifest()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(compiled.data)
+	return err
+}
+
+func (c compiledFiles) manifest() (*compiledManifest, error) {
+	if c.compiled.data != nil {
+		return c.compiled, nil
+	}
+
+	m, err := newManifest(databaseOnly{c})
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.marshal()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	*c.compiled = compiledManifest{
+		manifest: m,
+		data:     data,
+		sum:      ManifestFile{Size: len(data), SHA256: hex.EncodeToString(sum[:])},
+	}
+	return c.compiled, nil
+}
+
+// databaseOnly leaves the manifest out of a database's files, it can't list itself
+type databaseOnly struct {
+	compiledFiles
+}
+
+func (d databaseOnly) names() []string {
+	return Files()
+}
+
+// listDatabaseFiles lists the files of the database in dir, including its
+// manifest. Other files the player keeps beside them, like database_tmp.tcd,
+// aren't part of it.
+func listDatabaseFiles(dir string) ([]string, error) {
+	files := make([]string, 0)
+	for _, e := range append(Files(), ManifestName) {
+		if _, err := os.Stat(path.Join(dir, e)); err == nil {
+			files = append(files, path.Join(dir, e))
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+var BackupNotFoundError = errors.New("backup does not exist")
+
+// install writes files into targetDir as a set: files that differ from the ones
+// already there are written to a staging directory and synced first, then the
+// current database files they replace are moved into a new backup and the staged
+// ones moved into place. Database files not in the set are moved to the backup
+// too, as are the retired files beside them that exist. If that fails part way
+// the previous files are put back. Only the newest keep backups are kept, the
+// names of the files written are returned.
+func install(targetDir string, files fileSet, keep int, retire ...string) ([]string, error) {
+	existing, err := listDatabaseFiles(targetDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range retire {
+		if _, err := os.Stat(path.Join(targetDir, e)); err == nil {
+			existing = append(existing, path.Join(targetDir, e))
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	names := files.names()
+	changed := make([]string, 0, len(names))
+	unchanged := make([]string, 0, len(names))
+	for _, e := range names {
+		same, err := sameFile(path.Join(targetDir, e), files, e)
+		if err != nil {
+			return nil, err
+		} else if same {
+			unchanged = append(unchanged, e)
+		} else {
+			changed = append(changed, e)
+		}
+	}
+
+	stale := make([]string, 0)
+	for _, e := range existing {
+		found := false
+		for _, n := range names {
+			found = found || n == filepath.Base(e)
+		}
+		if !found {
+			stale = append(stale, filepath.Base(e))
+		}
+	}
+
+	if len(changed) == 0 && len(stale) == 0 {
+		return changed, nil
+	}
+
+	staging := path.Join(targetDir, stagingDirName)
+	err = os.RemoveAll(staging)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Mkdir(staging, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	for _, e := range changed {
+		err = writeSynced(path.Join(staging, e), func(w io.Writer) error {
+			return files.writeFile(e, w)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	syncDir(staging)
+
+	err = swap(targetDir, staging, changed, unchanged, stale, keep != 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return changed, pruneBackups(targetDir, keep)
+}
+
+// sameFile reports whether filename already holds the named file of files, by size then hash
+func sameFile(filename string, files fileSet, name string) (bool, error) {
+	want, err := files.sum(name)
+	if err != nil {
+		return false, err
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil || fi.Size() != int64(want.Size) {
+		return false, nil
+	}
+
+	_, existing, err := hashFile(filename)
+	if err != nil {
+		return false, nil
+	}
+	return existing == want.SHA256, nil
+}
+
+// swap moves the changed files from staging into targetDir, the files they
+// replace and the stale ones are moved into a new backup. Unchanged files are
+// hard linked into the backup too when it is to be kept, so it holds a whole
+// database. Where links aren't supported (e.g. FAT) they are left out rather
+// than copied, Restore finds them in newer backups or the database itself.
+func swap(targetDir string, staging string, changed []string, unchanged []string, stale []string, keepBackup bool) error {
+	backup, err := newBackupDir(targetDir)
+	if err != nil {
+		return err
+	}
+
+	moved := make([]string, 0, len(changed)+len(stale))
+	rollback := func(installed []string, cause error) error {
+		for _, e := range installed {
+			_ = os.Remove(path.Join(targetDir, e))
+		}
+		for _, e := range moved {
+			_ = os.Rename(path.Join(backup, e), path.Join(targetDir, e))
+		}
+		_ = os.RemoveAll(backup)
+		return cause
+	}
+
+	for _, e := range append(append([]string{}, changed...), stale...) {
+		if _, err := os.Stat(path.Join(targetDir, e)); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(path.Join(targetDir, e), path.Join(backup, e)); err != nil {
+			return rollback(nil, err)
+		}
+		moved = append(moved, e)
+	}
+
+	if keepBackup {
+		for _, e := range unchanged {
+			_ = os.Link(path.Join(targetDir, e), path.Join(backup, e))
+		}
+	}
+
+	// Only removed if nothing was backed up, an empty backup would push out real ones
+	_ = os.Remove(backup)
+
+	installed := make([]string, 0, len(changed))
+	for _, e := range changed {
+		if err := os.Rename(path.Join(staging, e), path.Join(targetDir, e)); err != nil {
+			return rollback(installed, err)
+		}
+		installed = append(installed, e)
+	}
+
+	syncDir(targetDir)
+	return nil
+}
+
+func writeSynced(filename string, write func(w io.Writer) error) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncDir syncs a directory's entries, not every platform supports this so errors are ignored
+func syncDir(dir string) {
+	if f, err := os.Open(dir); err == nil {
+		_ = f.Sync()
+		_ = f.Close()
+	}
+}
+
+func newBackupDir(targetDir string) (string, error) {
+	name := time.Now().Format(backupFormat)
+	dir := path.Join(targetDir, BackupDirName, name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			break
+		}
+		dir = path.Join(targetDir, BackupDirName, fmt.Sprintf("%s-%d", name, i))
+	}
+
+	return dir, os.MkdirAll(dir, os.ModePerm)
+}
+
+// Backups lists the backups of the database in targetDir, oldest first
+func Backups(targetDir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(path.Join(targetDir, BackupDirName))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	backups := make([]string, 0, len(infos))
+	for _, e := range infos {
+		if e.IsDir() {
+			backups = append(backups, e.Name())
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func pruneBackups(targetDir string, keep int) error {
+	if keep < 0 {
+		return nil
+	}
+
+	backups, err := Backups(targetDir)
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > keep {
+		err = os.RemoveAll(path.Join(targetDir, BackupDirName, backups[0]))
+		if err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Restore replaces the database in targetDir with the backup named name, the
+// current database is backed up first so a restore can be undone, and no
+// backups are pruned
+func Restore(targetDir string, name string) error {
+	backups, err := Backups(targetDir)
+	if err != nil {
+		return err
+	}
+
+	if indexOf(backups, name) < 0 {
+		return fmt.Errorf("%w: %s", BackupNotFoundError, name)
+	}
+
+	// A backup made without hard links only holds the files that were replaced,
+	// the others were the same as in the next newer backup or the database
+	dirs := make([]string, 0)
+	for _, e := range backups[indexOf(backups, name):] {
+		dirs = append(dirs, path.Join(targetDir, BackupDirName, e))
+	}
+	dirs = append(dirs, targetDir)
+
+	files := make(memoryFiles)
+	for _, e := range Files() {
+		files[e], err = readFirst(dirs, e)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The manifest is only restored with the backup's own, it would only match
+	// the database files it was written with
+	manifest, err := ioutil.ReadFile(path.Join(targetDir, BackupDirName, name, ManifestName))
+	if err == nil {
+		files[ManifestName] = manifest
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	_, err = install(targetDir, files, -1)
+	return err
+}
+
+func indexOf(names []string, name string) int {
+	for i, e := range names {
+		if e == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// readFirst reads the named file from the first of dirs that has it
+func readFirst(dirs []string, name string) ([]byte, error) {
+	for _, e := range dirs {
+		data, err := ioutil.ReadFile(path.Join(e, name))
+		if err == nil {
+			return data, nil
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", os.ErrNotExist, name)
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "rbdbsave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// readDatabase reads every file of the database in dir
+func readDatabase(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	files := make(map[string][]byte)
+	for _, e := range append(Files(), ManifestName) {
+		data, err := ioutil.ReadFile(path.Join(dir, e))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e] = data
+	}
+	return files
+}
+
+func assertDatabase(t *testing.T, dir string, want map[string][]byte) {
+	t.Helper()
+	for k, v := range readDatabase(t, dir) {
+		if !bytes.Equal(v, want[k]) {
+			t.Errorf("%s differs from the saved database", k)
+		}
+	}
+}
+
+func backups(t *testing.T, dir string) []string {
+	t.Helper()
+	b, err := Backups(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// saveStates saves d after each of changes, returning what was saved each time
+func saveStates(t *testing.T, dir string, d *Database, changes ...func()) []map[string][]byte {
+	t.Helper()
+	states := make([]map[string][]byte, 0, len(changes))
+	for _, change := range changes {
+		change()
+		if _, err := d.Save(dir); err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, readDatabase(t, dir))
+	}
+	return states
+}
+
+func TestRestore(t *testing.T) {
+	dir := tempDir(t)
+	d := New(false)
+	tracks := syntheticLibrary(24)
+	states := saveStates(t, dir, &d,
+		func() { _ = d.Add(tracks[:12]...) },
+		func() { _ = d.Add(tracks[12:]...) },
+	)
+
+	b := backups(t, dir)
+	if len(b) != 1 {
+		t.Fatalf("%d backups, want 1", len(b))
+	}
+	assertDatabase(t, path.Join(dir, BackupDirName, b[0]), states[0])
+
+	if err := Restore(dir, b[0]); err != nil {
+		t.Fatal(err)
+	}
+	assertDatabase(t, dir, states[0])
+
+	// The restored over database is backed up so the restore can be undone
+	if b = backups(t, dir); len(b) != 2 {
+		t.Fatalf("%d backups after restoring, want 2", len(b))
+	}
+	assertDatabase(t, path.Join(dir, BackupDirName, b[1]), states[1])
+}
+
+func TestRestoreWithoutLinks(t *testing.T) {
+	dir := tempDir(t)
+	d := New(false)
+	d.SetKeepOrder(true)
+	tracks := syntheticLibrary(12)
+	states := saveStates(t, dir, &d,
+		func() { _ = d.Add(tracks...) },
+		func() {
+			tracks[0].Title = "Retitled"
+			_ = d.Update(tracks[0])
+		},
+		func() {
+			tracks[0].Genre = "Regenred"
+			_ = d.Update(tracks[0])
+		},
+	)
+
+	// Leave out of each backup the files the next database didn't change, as
+	// where hard links aren't supported
+	b := backups(t, dir)
+	if len(b) != 2 {
+		t.Fatalf("%d backups, want 2", len(b))
+	}
+	fromNewer, fromDatabase := 0, 0
+	for _, e := range Files() {
+		for i, backup := range b {
+			if bytes.Equal(states[i][e], states[i+1][e]) {
+				if err := os.Remove(path.Join(dir, BackupDirName, backup, e)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if bytes.Equal(states[0][e], states[1][e]) && !bytes.Equal(states[1][e], states[2][e]) {
+			fromNewer++
+		} else if bytes.Equal(states[0][e], states[2][e]) {
+			fromDatabase++
+		}
+	}
+	if fromNewer == 0 || fromDatabase == 0 {
+		t.Fatalf("%d files only in a newer backup and %d only in the database, want some of each", fromNewer, fromDatabase)
+	}
+
+	if err := Restore(dir, b[0]); err != nil {
+		t.Fatal(err)
+	}
+	assertDatabase(t, dir, states[0])
+
+	m, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff, err := m.Verify(dir); err != nil || len(diff) != 0 {
+		t.Errorf("Verify() = %v, %v, want no differences", diff, err)
+	}
+}
+
+func TestSwapRollsBack(t *testing.T) {
+	dir := tempDir(t)
+	staging := path.Join(dir, stagingDirName)
+	if err := os.Mkdir(staging, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := map[string][]byte{"a": []byte("old a"), "b": []byte("old b"), "stale": []byte("old stale")}
+	for k, v := range previous {
+		if err := ioutil.WriteFile(path.Join(dir, k), v, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// b is missing from staging, so installing it fails after a is installed
+	if err := ioutil.WriteFile(path.Join(staging, "a"), []byte("new a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := swap(dir, staging, []string{"a", "b"}, nil, []string{"stale"}, true)
+	if err == nil {
+		t.Fatal("swap() succeeded without b to install")
+	}
+
+	for k, v := range previous {
+		data, err := ioutil.ReadFile(path.Join(dir, k))
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(data, v) {
+			t.Errorf("%s is %q after rolling back, want %q", k, data, v)
+		}
+	}
+	if b := backups(t, dir); len(b) != 0 {
+		t.Errorf("backups %v left after rolling back", b)
+	}
+}
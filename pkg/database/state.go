@@ -0,0 +1,45 @@
+package database
+
+import (
+	"rbdbtools/pkg/decoder"
+)
+
+// State is the index header that carries over between generations of a database
+type State struct {
+	// Serial goes up each time a track is played, LastPlayed values are relative to it
+	Serial uint32
+	// CommitId goes up each time the database is committed
+	CommitId uint32
+	// Dirty is set while the player has changes not yet written
+	Dirty bool
+}
+
+func NewState() State {
+	return State{
+		CommitId: 1,
+	}
+}
+
+// LoadState reads the state of the database in dbPath
+func LoadState(dbPath string) (State, error) {
+	header, _, err := decoder.DecodeIndexHeader(dbPath)
+	if err != nil {
+		return State{}, err
+	}
+
+	return State{
+		Serial:   header.Serial,
+		CommitId: header.CommitId,
+		Dirty:    header.Dirty,
+	}, nil
+}
+
+// Next returns the state of a database regenerated from this one: the serial is
+// kept so LastPlayed stays meaningful, the commit id is advanced and, as
+// everything is written, it is no longer dirty
+func (s State) Next() State {
+	return State{
+		Serial:   s.Serial,
+		CommitId: s.CommitId + 1,
+	}
+}
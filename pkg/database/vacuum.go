@@ -0,0 +1,58 @@
+package database
+
+import (
+	"os"
+	"path"
+	"time"
+)
+
+// FileSavings is the size of a database file before and after vacuuming
+type FileSavings struct {
+	Filename string
+	Before   int
+	After    int
+}
+
+func (f FileSavings) Saved() int {
+	return f.Before - f.After
+}
+
+// Vacuum drops deleted entries, and the strings only they referenced, from the
+// database in dbPath and saves it to targetDir, which may be dbPath. Live
+// entries keep their statistics, their strings and their order, and the index
+// keeps its serial and commit id.
+func Vacuum(dbPath string, targetDir string) ([]FileSavings, error) {
+	// Mtimes are decoded and encoded in the same zone, so any zone keeps them as they are
+	d, err := Load(dbPath, time.UTC)
+	if err != nil {
+		return nil, err
+	}
+
+	savings := make([]FileSavings, 0)
+	for _, k := range Files() {
+		fi, err := os.Stat(path.Join(dbPath, k))
+		if err != nil {
+			return nil, err
+		}
+		savings = append(savings, FileSavings{
+			Filename: k,
+			Before:   int(fi.Size()),
+		})
+	}
+
+	d.SetKeepOrder(true)
+	d.Compact()
+	_, err = d.Save(targetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range savings {
+		savings[i].After, err = d.FileSize(savings[i].Filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return savings, nil
+}
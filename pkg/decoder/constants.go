@@ -1,6 +1,9 @@
 package decoder
 
-import "errors"
+import (
+	"errors"
+	"rbdbtools/pkg/tcformat"
+)
 
 const (
 	artists      = "artists"
@@ -13,46 +16,39 @@ const (
 	albumArtists = "albumArtists"
 	groupings    = "groupings"
 	index        = "index"
-
-	artistsDB      = "database_0.tcd"
-	albumsDB       = "database_1.tcd"
-	genresDB       = "database_2.tcd"
-	titlesDB       = "database_3.tcd"
-	filenamesDB    = "database_4.tcd"
-	composersDB    = "database_5.tcd"
-	commentsDB     = "database_6.tcd"
-	albumArtistsDB = "database_7.tcd"
-	groupingsDB    = "database_8.tcd"
-	indexDB        = "database_idx.tcd"
 )
 
 var (
-	InvalidHeaderError = errors.New("header is not valid")
+	InvalidHeaderError   = errors.New("header is not valid")
+	NoDatabasesError     = errors.New("no database files were found")
+	EndianMismatchError  = errors.New("database endian doesn't match between files")
+	VersionMismatchError = errors.New("database version doesn't match between files")
 )
 
 var (
-	nameToDatabase = map[string]string{
-		artists:      artistsDB,
-		albums:       albumsDB,
-		genres:       genresDB,
-		titles:       titlesDB,
-		filenames:    filenamesDB,
-		composers:    composersDB,
-		comments:     commentsDB,
-		albumArtists: albumArtistsDB,
-		groupings:    groupingsDB,
-		index:        indexDB,
-	}
-	databaseToName = map[string]string{
-		artistsDB:      artists,
-		albumsDB:       albums,
-		genresDB:       genres,
-		titlesDB:       titles,
-		filenamesDB:    filenames,
-		composersDB:    composers,
-		commentsDB:     comments,
-		albumArtistsDB: albumArtists,
-		groupingsDB:    groupings,
-		indexDB:        index,
+	tagToName = [tcformat.StringTagCount]string{
+		tcformat.Artist:      artists,
+		tcformat.Album:       albums,
+		tcformat.Genre:       genres,
+		tcformat.Title:       titles,
+		tcformat.Filename:    filenames,
+		tcformat.Composer:    composers,
+		tcformat.Comment:     comments,
+		tcformat.AlbumArtist: albumArtists,
+		tcformat.Grouping:    groupings,
 	}
+	nameToDatabase = func() map[string]string {
+		m := map[string]string{index: tcformat.IndexFilename}
+		for k, v := range tagToName {
+			m[v] = tcformat.Tag(k).Filename()
+		}
+		return m
+	}()
+	databaseToName = func() map[string]string {
+		m := make(map[string]string)
+		for k, v := range nameToDatabase {
+			m[v] = k
+		}
+		return m
+	}()
 )
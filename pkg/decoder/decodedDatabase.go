@@ -1,17 +1,33 @@
 package decoder
 
+import (
+	"fmt"
+	"rbdbtools/pkg/tcformat"
+	"sort"
+
+	"golang.org/x/text/unicode/norm"
+)
+
 type DecodedDatabases struct {
 	Tags      map[string]TagCache
 	Index     IndexHeader
 	BigEndian bool
+	// Version detected from the magic of the files
+	Version uint32
+	// Files that were selected but don't exist
+	Missing []string
+	// Files that were selected but are too short to have a header, such as
+	// ones that were only partly copied
+	Invalid  []string
+	hasIndex bool
 }
 
 type Header struct {
 	Database string `csv:"database_name"`
 	Filename string `csv:"filename"`
 	Version  string `csv:"database_version"`
-	Size     int32  `csv:"file_size"`
-	Entries  int32  `csv:"number_entries"`
+	Size     uint32 `csv:"file_size"`
+	Entries  uint32 `csv:"number_entries"`
 }
 
 type TagCache struct {
@@ -21,21 +37,33 @@ type TagCache struct {
 
 type TagCacheEntry struct {
 	Offset   string `csv:"offset"`
-	Size     int32  `csv:"data_length"`
-	Index    int32  `csv:"index_value"`
+	Size     uint32 `csv:"data_length"`
+	Index    uint32 `csv:"index_value"`
 	Data     string `csv:"data"`
 	PaddedXs int    `csv:"padding"`
 }
 
+// NormalizationConflict is a tag that is only different from another in the same
+// database by its unicode normalisation, so the player shows both as the same value
+type NormalizationConflict struct {
+	Database       string `csv:"database_name"`
+	Offset         string `csv:"offset"`
+	Data           string `csv:"data"`
+	ConflictOffset string `csv:"conflict_offset"`
+	ConflictData   string `csv:"conflict_data"`
+}
+
 type IndexHeader struct {
-	Header         Header
-	Serial         int32 `csv:"serial"`
-	CommitId       int32 `csv:"commit_id"`
-	Dirty          bool  `csv:"dirty"`
-	EntriesOffsets []IndexEntry
-	EntriesTags    []IndexEntry
+	Header   Header
+	Magic    uint32
+	Serial   uint32 `csv:"serial"`
+	CommitId uint32 `csv:"commit_id"`
+	Dirty    bool   `csv:"dirty"`
+	Entries  []Entry
 }
 
+// IndexEntry is a row of the index dumps, string tags are either their
+// values or their offsets
 type IndexEntry struct {
 	Index           int    `csv:"index"`
 	Artist          string `csv:"artist"`
@@ -47,28 +75,38 @@ type IndexEntry struct {
 	Comment         string `csv:"comment"`
 	AlbumArtist     string `csv:"album_artist"`
 	Grouping        string `csv:"grouping"`
-	Year            int32  `csv:"year"`
-	DiscNumber      int32  `csv:"disc_number"`
-	TrackNumber     int32  `csv:"track_number"`
-	Bitrate         int32  `csv:"bitrate"`
-	Length          int32  `csv:"length"`
-	PlayCount       int32  `csv:"play_count"`
-	Rating          int32  `csv:"rating"`
-	PlayTime        int32  `csv:"playtime"`
-	LastPlayed      int32  `csv:"last_played"`
-	CommitId        int32  `csv:"commit_id"`
-	Mtime           int32  `csv:"mtime"`
-	LastElapsed     int32  `csv:"last_elapsed"`
-	LastOffset      int32  `csv:"last_offset"`
+	Year            uint32 `csv:"year"`
+	DiscNumber      uint32 `csv:"disc_number"`
+	TrackNumber     uint32 `csv:"track_number"`
+	Bitrate         uint32 `csv:"bitrate"`
+	Length          uint32 `csv:"length"`
+	PlayCount       uint32 `csv:"play_count"`
+	Rating          uint32 `csv:"rating"`
+	PlayTime        uint32 `csv:"playtime"`
+	LastPlayed      uint32 `csv:"last_played"`
+	PlaysSinceLast  int32  `csv:"plays_since_last_played"`
+	CommitId        uint32 `csv:"commit_id"`
+	Mtime           uint32 `csv:"mtime"`
+	LastElapsed     uint32 `csv:"last_elapsed"`
+	LastOffset      uint32 `csv:"last_offset"`
 	Flags           string `csv:"flags"`
 	FlagDeleted     bool   `csv:"FLAG_DELETED"`
+	FlagDirCache    bool   `csv:"FLAG_DIRCACHE"`
 	FlagDirty       bool   `csv:"FLAG_DIRTY"`
 	FlagTrackNumGen bool   `csv:"FLAG_TRKNUMGEN"`
 	FlagResurrected bool   `csv:"FLAG_RESURRECTED"`
 }
 
+// HasIndex is whether the index was decoded, without it there are no index entries
+func (db *DecodedDatabases) HasIndex() bool {
+	return db.hasIndex
+}
+
 func (db *DecodedDatabases) GetHeaders() []Header {
-	headers := []Header{db.Index.Header}
+	headers := make([]Header, 0, len(db.Tags)+1)
+	if db.hasIndex {
+		headers = append(headers, db.Index.Header)
+	}
 
 	for _, v := range db.Tags {
 		headers = append(headers, v.Header)
@@ -82,11 +120,60 @@ func (db *DecodedDatabases) GetIndexHeader() []Header {
 }
 
 func (db *DecodedDatabases) GetIndexTags() []IndexEntry {
-	return db.Index.EntriesTags
+	return db.indexRows(func(e Entry, t tcformat.Tag) string {
+		if e.Resolved(t) {
+			return e.Tag(t)
+		} else if _, decoded := db.Tags[tagToName[t]]; !decoded {
+			return fmt.Sprintf("DATABASE %s WAS NOT DECODED", tagToName[t])
+		}
+		return fmt.Sprintf("OFFSET 0x%08X DOES NOT HAVE A VALUE IN DATABASE %s", e.Offsets[t], tagToName[t])
+	})
 }
 
 func (db *DecodedDatabases) GetIndexOffsets() []IndexEntry {
-	return db.Index.EntriesOffsets
+	return db.indexRows(func(e Entry, t tcformat.Tag) string {
+		return fmt.Sprintf("0x%08X", e.Offsets[t])
+	})
+}
+
+// indexRows returns a row for each entry of the index, str gives the value of their string tags
+func (db *DecodedDatabases) indexRows(str func(e Entry, t tcformat.Tag) string) []IndexEntry {
+	rows := make([]IndexEntry, len(db.Index.Entries))
+	for i, e := range db.Index.Entries {
+		rows[i] = IndexEntry{
+			Index:           e.Index,
+			Artist:          str(e, tcformat.Artist),
+			Album:           str(e, tcformat.Album),
+			Genre:           str(e, tcformat.Genre),
+			Title:           str(e, tcformat.Title),
+			Filename:        str(e, tcformat.Filename),
+			Composer:        str(e, tcformat.Composer),
+			Comment:         str(e, tcformat.Comment),
+			AlbumArtist:     str(e, tcformat.AlbumArtist),
+			Grouping:        str(e, tcformat.Grouping),
+			Year:            e.Year,
+			DiscNumber:      e.DiscNumber,
+			TrackNumber:     e.TrackNumber,
+			Bitrate:         e.Bitrate,
+			Length:          e.Length,
+			PlayCount:       e.PlayCount,
+			Rating:          e.Rating,
+			PlayTime:        e.PlayTime,
+			LastPlayed:      e.LastPlayed,
+			PlaysSinceLast:  int32(e.PlaysSinceLastPlayed(db.Index.Serial)),
+			CommitId:        e.CommitId,
+			Mtime:           e.Mtime,
+			LastElapsed:     e.LastElapsed,
+			LastOffset:      e.LastOffset,
+			Flags:           fmt.Sprintf("0x%08X", uint32(e.Flags)),
+			FlagDeleted:     e.Flags.Has(FlagDeleted),
+			FlagDirCache:    e.Flags.Has(FlagDirCache),
+			FlagDirty:       e.Flags.Has(FlagDirty),
+			FlagTrackNumGen: e.Flags.Has(FlagTrackNumGen),
+			FlagResurrected: e.Flags.Has(FlagResurrected),
+		}
+	}
+	return rows
 }
 
 func (db *DecodedDatabases) GetEntries(database string) []TagCacheEntry {
@@ -100,3 +187,29 @@ func (db *DecodedDatabases) GetDatabases() []string {
 	}
 	return dbs
 }
+
+func (db *DecodedDatabases) GetNormalizationConflicts() []NormalizationConflict {
+	conflicts := make([]NormalizationConflict, 0)
+
+	dbs := db.GetDatabases()
+	sort.Strings(dbs)
+	for _, d := range dbs {
+		seen := make(map[string]TagCacheEntry)
+		for _, e := range db.Tags[d].Entries {
+			k := norm.NFC.String(e.Data)
+			if first, exists := seen[k]; !exists {
+				seen[k] = e
+			} else if first.Data != e.Data {
+				conflicts = append(conflicts, NormalizationConflict{
+					Database:       d,
+					Offset:         e.Offset,
+					Data:           e.Data,
+					ConflictOffset: first.Offset,
+					ConflictData:   first.Data,
+				})
+			}
+		}
+	}
+
+	return conflicts
+}
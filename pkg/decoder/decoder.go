@@ -1,29 +1,89 @@
 package decoder
 
 import (
-	"errors"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"path"
-	"rbdbtools/tools"
+	"rbdbtools/pkg/tcformat"
+	"runtime"
+	"sort"
+	"sync"
 )
 
-type offsetCache map[string]map[int32]string
+type offsetCache map[string]map[uint32]string
+
+// Options select which files DecodeDatabasesWith decodes
+type Options struct {
+	// Files to decode by filename or database name, such as "database_0.tcd" or
+	// "artists". Every file is decoded when empty.
+	Files []string
+	// AllowMissing skips files that don't exist or are too short to have a
+	// header instead of failing, they are listed in Missing and Invalid of the
+	// decoded databases
+	AllowMissing bool
+}
+
+// files returns the filenames selected by the options
+func (o Options) files() ([]string, error) {
+	if len(o.Files) == 0 {
+		return tcformat.Filenames(), nil
+	}
+
+	selected := make(map[string]bool)
+	for _, e := range o.Files {
+		if filename, exists := nameToDatabase[e]; exists {
+			selected[filename] = true
+		} else if _, exists := databaseToName[e]; exists {
+			selected[e] = true
+		} else {
+			return nil, fmt.Errorf("%q is not a database file", e)
+		}
+	}
+
+	files := make([]string, 0, len(selected))
+	for _, e := range tcformat.Filenames() {
+		if selected[e] {
+			files = append(files, e)
+		}
+	}
+	return files, nil
+}
 
 func DecodeDatabases(dbPath string) (*DecodedDatabases, error) {
-	databases := make(map[string][]byte)
+	return DecodeDatabasesWith(dbPath, Options{})
+}
+
+// DecodeDatabasesWith decodes the files of the database in dbPath selected by
+// opts. Offsets into tag files that weren't decoded are reported as unresolved.
+func DecodeDatabasesWith(dbPath string, opts Options) (*DecodedDatabases, error) {
+	files, err := opts.files()
+	if err != nil {
+		return nil, err
+	}
 
-	for k := range databaseToName {
-		if db, err := ioutil.ReadFile(path.Join(dbPath, k)); err != nil {
+	databases := make(map[string][]byte)
+	missing, invalid := make([]string, 0), make([]string, 0)
+	for _, k := range files {
+		if db, err := ioutil.ReadFile(path.Join(dbPath, k)); os.IsNotExist(err) && opts.AllowMissing {
+			missing = append(missing, k)
+		} else if err != nil {
 			return nil, err
-		} else if len(db) < 12 {
-			return nil, InvalidHeaderError
+		} else if len(db) < tcformat.HeaderSize && opts.AllowMissing {
+			invalid = append(invalid, k)
+		} else if len(db) < tcformat.HeaderSize {
+			return nil, fmt.Errorf("%w: %s", InvalidHeaderError, k)
 		} else {
 			databases[k] = db
 		}
 	}
+	if len(databases) == 0 {
+		return nil, NoDatabasesError
+	}
 
-	bigEndian, err := isBigEndian(databases)
+	bigEndian, version, err := detectEndianness(databases)
 	if err != nil {
 		return nil, err
 	}
@@ -31,19 +91,18 @@ func DecodeDatabases(dbPath string) (*DecodedDatabases, error) {
 	decoded := DecodedDatabases{
 		Tags:      make(map[string]TagCache),
 		BigEndian: bigEndian,
+		Version:   version,
+		Missing:   missing,
+		Invalid:   invalid,
 	}
 
 	for k, v := range databases {
 		if name := databaseToName[k]; name == index {
-			if len(v) < 24 {
-				return nil, InvalidHeaderError
-			}
-			decoded.Index = IndexHeader{
-				Header:   decodeHeader(v, name, bigEndian),
-				Serial:   tools.BytesNum(v[12:16], bigEndian),
-				CommitId: tools.BytesNum(v[16:20], bigEndian),
-				Dirty:    tools.BytesNum(v[20:24], bigEndian) != 0,
+			decoded.Index, err = decodeIndexHeader(v, bigEndian)
+			if err != nil {
+				return nil, err
 			}
+			decoded.hasIndex = true
 		} else {
 			decoded.Tags[name] = TagCache{
 				Header: decodeHeader(v, name, bigEndian),
@@ -58,159 +117,240 @@ func DecodeDatabases(dbPath string) (*DecodedDatabases, error) {
 			Entries: tags[k],
 		}
 	}
-	decoded.Index.EntriesTags, decoded.Index.EntriesOffsets = decodeIndexEntries(databases[indexDB], bigEndian, offsets)
+	if decoded.hasIndex {
+		decoded.Index.Entries = decodeIndexEntries(databases[tcformat.IndexFilename], bigEndian, offsets)
+	}
 
 	return &decoded, nil
 }
 
-func isBigEndian(databases map[string][]byte) (bool, error) {
-	var bigEndian *bool
-	for _, v := range databases {
-		thisDBIsBigEndian := string(v[0]) == "T"
-		if bigEndian != nil && *bigEndian != thisDBIsBigEndian {
-			return false, errors.New("database endian doesn't match between files")
-		} else {
-			bigEndian = new(bool)
+// DecodeIndexHeader decodes only the header of the index in dbPath, returning it
+// and whether the database is big endian
+func DecodeIndexHeader(dbPath string) (IndexHeader, bool, error) {
+	db, err := ioutil.ReadFile(path.Join(dbPath, tcformat.IndexFilename))
+	if err != nil {
+		return IndexHeader{}, false, err
+	} else if len(db) < tcformat.HeaderSize {
+		return IndexHeader{}, false, InvalidHeaderError
+	}
+
+	bigEndian, _, err := detectEndianness(map[string][]byte{tcformat.IndexFilename: db})
+	if err != nil {
+		return IndexHeader{}, false, err
+	}
+
+	header, err := decodeIndexHeader(db, bigEndian)
+	return header, bigEndian, err
+}
+
+func decodeIndexHeader(db []byte, bigEndian bool) (IndexHeader, error) {
+	header, err := tcformat.ReadIndexHeader(db, tcformat.ByteOrder(bigEndian))
+	if err != nil {
+		return IndexHeader{}, InvalidHeaderError
+	}
+	return IndexHeader{
+		Header:   decodeHeader(db, index, bigEndian),
+		Magic:    header.Magic,
+		Serial:   header.Serial,
+		CommitId: header.CommitId,
+		Dirty:    header.Dirty,
+	}, nil
+}
+
+// detectEndianness checks the magic of every file, returning whether they are
+// big endian and their version
+func detectEndianness(databases map[string][]byte) (bool, uint32, error) {
+	names := make([]string, 0, len(databases))
+	for k := range databases {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var order binary.ByteOrder
+	var version uint32
+	for _, k := range names {
+		o, v, err := tcformat.ReadMagic(databases[k])
+		if err != nil {
+			return false, 0, fmt.Errorf("%s: %w", k, err)
+		}
+
+		if order == nil {
+			order, version = o, v
+		} else if o != order {
+			return false, 0, fmt.Errorf("%w: %s", EndianMismatchError, k)
+		} else if v != version {
+			return false, 0, fmt.Errorf("%w: %s is 0x%08X, expected 0x%08X", VersionMismatchError, k, v, version)
 		}
-		*bigEndian = thisDBIsBigEndian
 	}
-	if bigEndian == nil {
-		return false, errors.New("databases weren't loaded correctly")
+	if order == nil {
+		return false, 0, NoDatabasesError
 	}
-	return *bigEndian, nil
+	return order == binary.BigEndian, version, nil
+}
+
+// DetectEndianness reads the magic of the database files in dir, returning
+// whether they are big endian and their version. Only the files that exist are
+// checked, so it works on partial databases.
+func DetectEndianness(dir string) (bool, uint32, error) {
+	magics := make(map[string][]byte)
+	for _, k := range tcformat.Filenames() {
+		f, err := os.Open(path.Join(dir, k))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return false, 0, err
+		}
+
+		magic := make([]byte, 4)
+		_, err = io.ReadFull(f, magic)
+		f.Close()
+		if err != nil {
+			return false, 0, fmt.Errorf("%w: %s", InvalidHeaderError, k)
+		}
+		magics[k] = magic
+	}
+
+	return detectEndianness(magics)
 }
 
 func decodeHeader(database []byte, dbName string, bigEndian bool) Header {
-	ver := database[:4]
-	size := database[4:8]
-	entries := database[8:12]
+	// Files are checked to be long enough for a header when they are read
+	header, _ := tcformat.ReadHeader(database, tcformat.ByteOrder(bigEndian))
 
 	return Header{
 		Database: dbName,
 		Filename: nameToDatabase[dbName],
-		Version:  fmt.Sprintf("0x%08X", tools.BytesNum(ver, bigEndian)),
-		Size:     tools.BytesNum(size, bigEndian),
-		Entries:  tools.BytesNum(entries, bigEndian),
+		Version:  fmt.Sprintf("0x%08X", header.Magic),
+		Size:     header.Size,
+		Entries:  header.Entries,
 	}
 }
 
+// decodeTagCaches decodes each tag file in its own goroutine
 func decodeTagCaches(bigEndian bool, bytes map[string][]byte) (offsetCache, map[string][]TagCacheEntry) {
 	cache := make(offsetCache)
 	entries := make(map[string][]TagCacheEntry)
+	order := tcformat.ByteOrder(bigEndian)
 
-	for v := range databaseToName {
-		if v == indexDB {
+	type result struct {
+		name    string
+		entries []TagCacheEntry
+		offsets map[uint32]string
+	}
+	results := make(chan result)
+
+	n := 0
+	for v, data := range bytes {
+		if v == tcformat.IndexFilename {
 			continue
-		} else {
-			entries[databaseToName[v]] = make([]TagCacheEntry, 0)
 		}
+		n++
 
-		for i := 12; i < len(bytes[v]); {
-			entry := TagCacheEntry{
-				Offset: fmt.Sprintf("0x%08X", int32(i)),
-				Size:   tools.BytesNum(bytes[v][i:i+4], bigEndian),
-				Index:  tools.BytesNum(bytes[v][i+4:i+8], bigEndian),
-			}
-
-			for end := i + 8; end < i+8+int(entry.Size); end++ {
-				if bytes[v][end] == 0x00 {
-					entry.Data = string(bytes[v][i+8 : end])
-					entry.PaddedXs = i + 8 + int(entry.Size) - (end + 1)
-					break
-				}
-			}
-
-			entries[databaseToName[v]] = append(entries[databaseToName[v]], entry)
-			cache.put(databaseToName[v], int32(i), entry.Data)
+		go func(data []byte, name string) {
+			entries, offsets := decodeTagCache(data, order)
+			results <- result{name, entries, offsets}
+		}(data, databaseToName[v])
+	}
 
-			i += int(entry.Size) + 8
-		}
+	for ; n > 0; n-- {
+		r := <-results
+		entries[r.name] = r.entries
+		cache[r.name] = r.offsets
 	}
 
 	return cache, entries
 }
 
-func decodeIndexEntries(index []byte, bigEndian bool, offsets offsetCache) ([]IndexEntry, []IndexEntry) {
-	entriesTags := make([]IndexEntry, 0)
-	entriesOffsets := make([]IndexEntry, 0)
-
-	for i, idx := 24, 0; i < len(index); i, idx = i+(23*4), idx+1 {
-		artistOffset := tools.BytesNum(index[i:i+4], bigEndian)
-		albumOffset := tools.BytesNum(index[i+4:i+8], bigEndian)
-		genreOffset := tools.BytesNum(index[i+8:i+12], bigEndian)
-		titleOffset := tools.BytesNum(index[i+12:i+16], bigEndian)
-		filenameOffset := tools.BytesNum(index[i+16:i+20], bigEndian)
-		composerOffset := tools.BytesNum(index[i+20:i+24], bigEndian)
-		commentOffset := tools.BytesNum(index[i+24:i+28], bigEndian)
-		albumArtistOffset := tools.BytesNum(index[i+28:i+32], bigEndian)
-		groupingOffset := tools.BytesNum(index[i+32:i+36], bigEndian)
-		flag := tools.BytesNum(index[i+88:i+92], bigEndian)
-
-		entryTag := IndexEntry{
-			Index:           idx,
-			Artist:          offsets.get(artists, artistOffset),
-			Album:           offsets.get(albums, albumOffset),
-			Genre:           offsets.get(genres, genreOffset),
-			Title:           offsets.get(titles, titleOffset),
-			Filename:        offsets.get(filenames, filenameOffset),
-			Composer:        offsets.get(composers, composerOffset),
-			Comment:         offsets.get(comments, commentOffset),
-			AlbumArtist:     offsets.get(albumArtists, albumArtistOffset),
-			Grouping:        offsets.get(groupings, groupingOffset),
-			Year:            tools.BytesNum(index[i+36:i+40], bigEndian),
-			DiscNumber:      tools.BytesNum(index[i+40:i+44], bigEndian),
-			TrackNumber:     tools.BytesNum(index[i+44:i+48], bigEndian),
-			Bitrate:         tools.BytesNum(index[i+48:i+52], bigEndian),
-			Length:          tools.BytesNum(index[i+52:i+56], bigEndian),
-			PlayCount:       tools.BytesNum(index[i+56:i+60], bigEndian),
-			Rating:          tools.BytesNum(index[i+60:i+64], bigEndian),
-			PlayTime:        tools.BytesNum(index[i+64:i+68], bigEndian),
-			LastPlayed:      tools.BytesNum(index[i+68:i+72], bigEndian),
-			CommitId:        tools.BytesNum(index[i+72:i+76], bigEndian),
-			Mtime:           tools.BytesNum(index[i+76:i+80], bigEndian),
-			LastElapsed:     tools.BytesNum(index[i+80:i+84], bigEndian),
-			LastOffset:      tools.BytesNum(index[i+84:i+88], bigEndian),
-			Flags:           fmt.Sprintf("0x%08X", flag),
-			FlagDeleted:     flag&int32(1) != 0,
-			FlagDirty:       flag&int32(1<<2) != 0,
-			FlagTrackNumGen: flag&int32(1<<3) != 0,
-			FlagResurrected: flag&int32(1<<4) != 0,
+func decodeTagCache(data []byte, order binary.ByteOrder) ([]TagCacheEntry, map[uint32]string) {
+	entries := make([]TagCacheEntry, 0)
+	offsets := make(map[uint32]string)
+
+	for i := tcformat.HeaderSize; i < len(data); {
+		e, err := tcformat.ReadTagEntry(data[i:], order)
+		if err != nil {
+			// Truncated entry, nothing after it can be read
+			break
 		}
 
-		entryOffset := entryTag
-		entryOffset.Artist = fmt.Sprintf("0x%08X", artistOffset)
-		entryOffset.Album = fmt.Sprintf("0x%08X", albumOffset)
-		entryOffset.Genre = fmt.Sprintf("0x%08X", genreOffset)
-		entryOffset.Title = fmt.Sprintf("0x%08X", titleOffset)
-		entryOffset.Filename = fmt.Sprintf("0x%08X", filenameOffset)
-		entryOffset.Composer = fmt.Sprintf("0x%08X", composerOffset)
-		entryOffset.Comment = fmt.Sprintf("0x%08X", commentOffset)
-		entryOffset.AlbumArtist = fmt.Sprintf("0x%08X", albumArtistOffset)
-		entryOffset.Grouping = fmt.Sprintf("0x%08X", groupingOffset)
-		entryOffset.Flags = fmt.Sprintf("0x%08X", flag)
+		entries = append(entries, TagCacheEntry{
+			Offset:   fmt.Sprintf("0x%08X", i),
+			Size:     e.Length,
+			Index:    e.Idx,
+			Data:     e.Data,
+			PaddedXs: e.Padding,
+		})
+		offsets[uint32(i)] = e.Data
 
-		entriesTags = append(entriesTags, entryTag)
-		entriesOffsets = append(entriesOffsets, entryOffset)
+		i += e.Size()
 	}
 
-	return entriesTags, entriesOffsets
+	return entries, offsets
 }
 
-func (oc offsetCache) get(database string, offset int32) string {
-	tcem, e := oc[database]
-	if !e {
-		return fmt.Sprintf("DATABASE %s DOES NOT EXIST", database)
+// decodeIndexEntries splits the index into a chunk for each CPU and decodes them
+// at the same time, offsets is only read so it can be shared
+func decodeIndexEntries(index []byte, bigEndian bool, offsets offsetCache) []Entry {
+	n := 0
+	if len(index) > tcformat.IndexHeaderSize {
+		n = (len(index) - tcformat.IndexHeaderSize) / tcformat.IndexEntrySize
 	}
-	tce, e := tcem[offset]
-	if !e {
-		return fmt.Sprintf("OFFSET 0x%08X DOES NOT HAVE A VALUE IN DATABASE %s", offset, database)
+	entries := make([]Entry, n)
+	order := tcformat.ByteOrder(bigEndian)
+
+	chunk := (n + runtime.NumCPU() - 1) / runtime.NumCPU()
+	wg := sync.WaitGroup{}
+	for start := 0; start < n; start += chunk {
+		end := start + chunk
+		if end > n {
+			end = n
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for idx := start; idx < end; idx++ {
+				e, _ := tcformat.ReadIndexEntry(index[tcformat.IndexHeaderSize+idx*tcformat.IndexEntrySize:], order)
+				entries[idx] = decodeIndexEntry(idx, e, offsets)
+			}
+		}(start, end)
 	}
-	return tce
+	wg.Wait()
+
+	return entries
 }
 
-func (oc offsetCache) put(database string, offset int32, value string) {
-	if _, e := oc[database]; !e {
-		oc[database] = make(map[int32]string)
+// decodeIndexEntry returns the entry with its string tags resolved
+func decodeIndexEntry(idx int, e tcformat.IndexEntry, offsets offsetCache) Entry {
+	entry := Entry{
+		Index:       idx,
+		Year:        e.Tags[tcformat.Year],
+		DiscNumber:  e.Tags[tcformat.DiscNumber],
+		TrackNumber: e.Tags[tcformat.TrackNumber],
+		Bitrate:     e.Tags[tcformat.Bitrate],
+		Length:      e.Tags[tcformat.Length],
+		PlayCount:   e.Tags[tcformat.PlayCount],
+		Rating:      e.Tags[tcformat.Rating],
+		PlayTime:    e.Tags[tcformat.PlayTime],
+		LastPlayed:  e.Tags[tcformat.LastPlayed],
+		CommitId:    e.Tags[tcformat.CommitId],
+		Mtime:       e.Tags[tcformat.Mtime],
+		LastElapsed: e.Tags[tcformat.LastElapsed],
+		LastOffset:  e.Tags[tcformat.LastOffset],
+		Flags:       Flags(e.Flags),
+	}
+
+	for t := tcformat.Tag(0); t < tcformat.StringTagCount; t++ {
+		entry.Offsets[t] = e.Tags[t]
+		value, resolved := offsets.lookup(tagToName[t], e.Tags[t])
+		entry.setTag(t, value)
+		entry.resolved[t] = resolved
 	}
-	oc[database][offset] = value
+
+	return entry
+}
+
+// lookup returns the tag at offset in database and whether there is one
+func (oc offsetCache) lookup(database string, offset uint32) (string, bool) {
+	tce, e := oc[database][offset]
+	return tce, e
 }
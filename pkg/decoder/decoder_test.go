@@ -0,0 +1,191 @@
+package decoder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"rbdbtools/pkg/tcformat"
+	"reflect"
+	"testing"
+)
+
+const (
+	syntheticEntries = 200000
+	// shortEntries is enough entries to spread over every worker with -short
+	shortEntries = 5000
+)
+
+// writeSyntheticDatabase writes a database of n entries to dir. Titles and
+// filenames are unique, every other string tag is shared by ten entries.
+func writeSyntheticDatabase(dir string, n int, bigEndian bool) error {
+	order := tcformat.ByteOrder(bigEndian)
+	offsets := make([][]uint32, tcformat.StringTagCount)
+
+	for t := tcformat.Tag(0); t < tcformat.StringTagCount; t++ {
+		count := n / 10
+		if t.Unique() {
+			count = n
+		}
+
+		buf := bytes.NewBuffer(make([]byte, tcformat.HeaderSize))
+		offsets[t] = make([]uint32, count)
+		for i := 0; i < count; i++ {
+			idx := uint32(tcformat.UniqueIdx)
+			if t.Unique() {
+				idx = uint32(i)
+			}
+
+			offsets[t][i] = uint32(buf.Len())
+			if err := tcformat.NewTagEntry(fmt.Sprintf("%s %d", t, i), idx, t).Write(buf, order); err != nil {
+				return err
+			}
+		}
+
+		data := buf.Bytes()
+		tcformat.Header{Magic: tcformat.Magic, Size: uint32(len(data) - tcformat.HeaderSize), Entries: uint32(count)}.Put(data, order)
+		if err := ioutil.WriteFile(path.Join(dir, t.Filename()), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	index := make([]byte, tcformat.IndexHeaderSize+n*tcformat.IndexEntrySize)
+	tcformat.IndexHeader{
+		Header:   tcformat.Header{Magic: tcformat.Magic, Size: uint32(len(index) - tcformat.HeaderSize), Entries: uint32(n)},
+		Serial:   uint32(n),
+		CommitId: 3,
+	}.Put(index, order)
+	for i := 0; i < n; i++ {
+		e := tcformat.IndexEntry{Flags: uint32(i % 4)}
+		for t := tcformat.Tag(0); t < tcformat.TagCount; t++ {
+			if t.IsString() {
+				e.Tags[t] = offsets[t][i%len(offsets[t])]
+			} else {
+				e.Tags[t] = uint32(i) + uint32(t)
+			}
+		}
+		e.Put(index[tcformat.IndexHeaderSize+i*tcformat.IndexEntrySize:], order)
+	}
+	return ioutil.WriteFile(path.Join(dir, tcformat.IndexFilename), index, 0644)
+}
+
+func syntheticDatabase(tb testing.TB, n int, bigEndian bool) string {
+	dir, err := ioutil.TempDir("", "rbdbtools")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err = writeSyntheticDatabase(dir, n, bigEndian); err != nil {
+		_ = os.RemoveAll(dir)
+		tb.Fatal(err)
+	}
+	return dir
+}
+
+// decodeSequentially decodes the tag files and index one entry at a time in order
+func decodeSequentially(t *testing.T, dir string, bigEndian bool) (map[string][]TagCacheEntry, []Entry) {
+	order := tcformat.ByteOrder(bigEndian)
+	offsets := make(offsetCache)
+	tags := make(map[string][]TagCacheEntry)
+	for k := tcformat.Tag(0); k < tcformat.StringTagCount; k++ {
+		data, err := ioutil.ReadFile(path.Join(dir, k.Filename()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags[tagToName[k]], offsets[tagToName[k]] = decodeTagCache(data, order)
+	}
+
+	index, err := ioutil.ReadFile(path.Join(dir, tcformat.IndexFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make([]Entry, 0)
+	for i := 0; tcformat.IndexHeaderSize+(i+1)*tcformat.IndexEntrySize <= len(index); i++ {
+		e, err := tcformat.ReadIndexEntry(index[tcformat.IndexHeaderSize+i*tcformat.IndexEntrySize:], order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, decodeIndexEntry(i, e, offsets))
+	}
+	return tags, entries
+}
+
+func TestConcurrentDecodeMatchesSequential(t *testing.T) {
+	n := syntheticEntries
+	if testing.Short() {
+		n = shortEntries
+	}
+
+	for _, bigEndian := range []bool{false, true} {
+		dir := syntheticDatabase(t, n, bigEndian)
+		defer os.RemoveAll(dir)
+
+		decoded, err := DecodeDatabases(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.BigEndian != bigEndian {
+			t.Fatalf("decoded as big endian %t, want %t", decoded.BigEndian, bigEndian)
+		}
+
+		if e := decoded.Index.Entries[12]; e.Title != "title 12" || e.Artist != "artist 12" || !e.Resolved(tcformat.Genre) {
+			t.Fatalf("entry 12 was not resolved: %+v", e)
+		}
+
+		tags, entries := decodeSequentially(t, dir, bigEndian)
+		if len(decoded.Index.Entries) != n {
+			t.Fatalf("decoded %d entries, want %d", len(decoded.Index.Entries), n)
+		}
+		if !reflect.DeepEqual(decoded.Index.Entries, entries) {
+			for i := range entries {
+				if !reflect.DeepEqual(decoded.Index.Entries[i], entries[i]) {
+					t.Fatalf("entry %d differs: %+v, want %+v", i, decoded.Index.Entries[i], entries[i])
+				}
+			}
+		}
+		for k, v := range tags {
+			if !reflect.DeepEqual(decoded.Tags[k].Entries, v) {
+				t.Errorf("%s differ", k)
+			}
+		}
+	}
+}
+
+func BenchmarkDecodeDatabases(b *testing.B) {
+	dir := syntheticDatabase(b, syntheticEntries, false)
+	defer os.RemoveAll(dir)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeDatabases(dir); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeSkipsShortFiles(t *testing.T) {
+	dir := syntheticDatabase(t, 100, false)
+	defer os.RemoveAll(dir)
+
+	short := tcformat.Album.Filename()
+	if err := ioutil.WriteFile(path.Join(dir, short), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecodeDatabases(dir); !errors.Is(err, InvalidHeaderError) {
+		t.Errorf("decoding without AllowMissing returned %v, want %v", err, InvalidHeaderError)
+	}
+
+	decoded, err := DecodeDatabasesWith(dir, Options{AllowMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Invalid, []string{short}) {
+		t.Errorf("invalid files are %v, want %v", decoded.Invalid, []string{short})
+	}
+	if e := decoded.Index.Entries[0]; e.Resolved(tcformat.Album) || e.Title != "title 0" {
+		t.Errorf("entry 0 is %+v, want its album unresolved and everything else resolved", e)
+	}
+}
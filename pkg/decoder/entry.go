@@ -0,0 +1,168 @@
+package decoder
+
+import (
+	"fmt"
+	"rbdbtools/pkg/tcformat"
+	"strings"
+	"time"
+)
+
+// Flags are the flags of an index entry
+type Flags uint32
+
+const (
+	FlagDeleted     Flags = tcformat.FlagDeleted
+	FlagDirCache    Flags = tcformat.FlagDirCache
+	FlagDirty       Flags = tcformat.FlagDirty
+	FlagTrackNumGen Flags = tcformat.FlagTrackNumGen
+	FlagResurrected Flags = tcformat.FlagResurrected
+)
+
+var flagNames = []struct {
+	flag Flags
+	name string
+}{
+	{FlagDeleted, "DELETED"},
+	{FlagDirCache, "DIRCACHE"},
+	{FlagDirty, "DIRTY"},
+	{FlagTrackNumGen, "TRKNUMGEN"},
+	{FlagResurrected, "RESURRECTED"},
+}
+
+// Has is whether every bit of flag is set
+func (f Flags) Has(flag Flags) bool {
+	return f&flag == flag
+}
+
+// String names the flags that are set, unknown bits are kept as hex
+func (f Flags) String() string {
+	names := make([]string, 0)
+	for _, e := range flagNames {
+		if f.Has(e.flag) {
+			names = append(names, e.name)
+			f &^= e.flag
+		}
+	}
+	if f != 0 || len(names) == 0 {
+		names = append(names, fmt.Sprintf("0x%08X", uint32(f)))
+	}
+	return strings.Join(names, "|")
+}
+
+// Entry is an entry of the index with its string tags resolved. Nothing is lost
+// decoding it, the offsets of the string tags are kept along with their values.
+type Entry struct {
+	Index int
+	// Offsets of the string tags into their files, by tag
+	Offsets     [tcformat.StringTagCount]uint32
+	Artist      string
+	Album       string
+	Genre       string
+	Title       string
+	Filename    string
+	Composer    string
+	Comment     string
+	AlbumArtist string
+	Grouping    string
+	Year        uint32
+	DiscNumber  uint32
+	TrackNumber uint32
+	// Bitrate in kbit/s
+	Bitrate uint32
+	// Length in milliseconds
+	Length    uint32
+	PlayCount uint32
+	Rating    uint32
+	// PlayTime is how long the track has been played altogether in milliseconds
+	PlayTime uint32
+	// LastPlayed is the serial of the database when the track was last played
+	LastPlayed uint32
+	CommitId   uint32
+	// Mtime is encoded as the player stores it, see tcformat.DecodeMtime
+	Mtime uint32
+	// LastElapsed is how far into the track playback last stopped in milliseconds
+	LastElapsed uint32
+	// LastOffset is how far into the file playback last stopped in bytes
+	LastOffset uint32
+	Flags      Flags
+
+	resolved [tcformat.StringTagCount]bool
+}
+
+// Tag returns the value of a string tag, empty if it isn't a string tag or it
+// couldn't be resolved
+func (e Entry) Tag(t tcformat.Tag) string {
+	switch t {
+	case tcformat.Artist:
+		return e.Artist
+	case tcformat.Album:
+		return e.Album
+	case tcformat.Genre:
+		return e.Genre
+	case tcformat.Title:
+		return e.Title
+	case tcformat.Filename:
+		return e.Filename
+	case tcformat.Composer:
+		return e.Composer
+	case tcformat.Comment:
+		return e.Comment
+	case tcformat.AlbumArtist:
+		return e.AlbumArtist
+	case tcformat.Grouping:
+		return e.Grouping
+	}
+	return ""
+}
+
+func (e *Entry) setTag(t tcformat.Tag, value string) {
+	switch t {
+	case tcformat.Artist:
+		e.Artist = value
+	case tcformat.Album:
+		e.Album = value
+	case tcformat.Genre:
+		e.Genre = value
+	case tcformat.Title:
+		e.Title = value
+	case tcformat.Filename:
+		e.Filename = value
+	case tcformat.Composer:
+		e.Composer = value
+	case tcformat.Comment:
+		e.Comment = value
+	case tcformat.AlbumArtist:
+		e.AlbumArtist = value
+	case tcformat.Grouping:
+		e.Grouping = value
+	}
+}
+
+// Resolved is whether the offset of a string tag pointed at an entry of its file
+func (e Entry) Resolved(t tcformat.Tag) bool {
+	return t.IsString() && e.resolved[t]
+}
+
+func (e Entry) Duration() time.Duration {
+	return time.Duration(e.Length) * time.Millisecond
+}
+
+// TimePlayed is how long the track has been played altogether
+func (e Entry) TimePlayed() time.Duration {
+	return time.Duration(e.PlayTime) * time.Millisecond
+}
+
+// Elapsed is how far into the track playback last stopped
+func (e Entry) Elapsed() time.Duration {
+	return time.Duration(e.LastElapsed) * time.Millisecond
+}
+
+// PlaysSinceLastPlayed is the number of plays of any track since this one was
+// played, given the serial of the database, or -1 if it has never been played
+func (e Entry) PlaysSinceLastPlayed(serial uint32) int {
+	if e.PlayCount == 0 {
+		return -1
+	}
+	// Last played is the serial at the time of playing, serial goes up with every play
+	return int(int32(serial - e.LastPlayed))
+}
@@ -0,0 +1,15 @@
+//go:build !darwin && !linux && !freebsd && !netbsd && !openbsd
+// +build !darwin,!linux,!freebsd,!netbsd,!openbsd
+
+package decoder
+
+import "io/ioutil"
+
+// mapFile reads filename into memory where files can't be mapped
+func mapFile(filename string) ([]byte, func() error, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
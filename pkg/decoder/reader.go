@@ -0,0 +1,153 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path"
+	"rbdbtools/pkg/tcformat"
+)
+
+// Reader reads a database a piece at a time instead of decoding all of it. Its
+// files are memory-mapped, so opening even a large database is instant and only
+// the parts that are read are loaded.
+type Reader struct {
+	// String tag files by tag, then the index
+	files  [tcformat.StringTagCount + 1][]byte
+	unmaps []func() error
+	order  binary.ByteOrder
+	header tcformat.IndexHeader
+}
+
+// Open maps the database in dbPath, it must be closed when no longer needed
+func Open(dbPath string) (*Reader, error) {
+	r := &Reader{}
+
+	byName := make(map[string][]byte)
+	for k, name := range tcformat.Filenames() {
+		data, unmap, err := mapFile(path.Join(dbPath, name))
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		r.files[k] = data
+		r.unmaps = append(r.unmaps, unmap)
+
+		if len(data) < tcformat.HeaderSize {
+			_ = r.Close()
+			return nil, fmt.Errorf("%w: %s", InvalidHeaderError, name)
+		}
+		byName[name] = data
+	}
+
+	bigEndian, _, err := detectEndianness(byName)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	r.order = tcformat.ByteOrder(bigEndian)
+
+	r.header, err = tcformat.ReadIndexHeader(r.index(), r.order)
+	if err != nil {
+		_ = r.Close()
+		return nil, InvalidHeaderError
+	}
+
+	return r, nil
+}
+
+// Close unmaps the files of the database, nothing read from it may be used afterwards
+func (r *Reader) Close() error {
+	var err error
+	for _, unmap := range r.unmaps {
+		if e := unmap(); e != nil && err == nil {
+			err = e
+		}
+	}
+	r.unmaps = nil
+	r.files = [tcformat.StringTagCount + 1][]byte{}
+	return err
+}
+
+func (r *Reader) index() []byte {
+	return r.files[tcformat.StringTagCount]
+}
+
+func (r *Reader) BigEndian() bool {
+	return r.order == binary.BigEndian
+}
+
+func (r *Reader) Header() tcformat.IndexHeader {
+	return r.header
+}
+
+// Len is the number of entries in the index, a truncated last entry isn't counted
+func (r *Reader) Len() int {
+	return (len(r.index()) - tcformat.IndexHeaderSize) / tcformat.IndexEntrySize
+}
+
+// Entry returns the i-th entry of the index
+func (r *Reader) Entry(i int) (tcformat.IndexEntry, error) {
+	if i < 0 || i >= r.Len() {
+		return tcformat.IndexEntry{}, fmt.Errorf("index entry %d is out of range, there are %d", i, r.Len())
+	}
+	return tcformat.ReadIndexEntry(r.index()[tcformat.IndexHeaderSize+i*tcformat.IndexEntrySize:], r.order)
+}
+
+// TagEntry returns the entry at offset in the file of a string tag
+func (r *Reader) TagEntry(tag tcformat.Tag, offset uint32) (tcformat.TagEntry, error) {
+	if !tag.IsString() {
+		return tcformat.TagEntry{}, fmt.Errorf("%s is not a string tag", tag)
+	}
+
+	file := r.files[tag]
+	if offset < tcformat.HeaderSize || uint64(offset) >= uint64(len(file)) {
+		return tcformat.TagEntry{}, fmt.Errorf("offset 0x%08X is not in %s", offset, tag.Filename())
+	}
+	return tcformat.ReadTagEntry(file[offset:], r.order)
+}
+
+// String returns the value of a string tag of the i-th entry of the index
+func (r *Reader) String(i int, tag tcformat.Tag) (string, error) {
+	e, err := r.Entry(i)
+	if err != nil {
+		return "", err
+	}
+
+	te, err := r.TagEntry(tag, e.Tags[tag])
+	return te.Data, err
+}
+
+// Entries calls fn with each entry of the index in order until it returns false
+func (r *Reader) Entries(fn func(i int, e tcformat.IndexEntry) bool) error {
+	for i := 0; i < r.Len(); i++ {
+		e, err := r.Entry(i)
+		if err != nil {
+			return err
+		}
+		if !fn(i, e) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// TagEntries calls fn with each entry in the file of a string tag and its offset,
+// in order until it returns false
+func (r *Reader) TagEntries(tag tcformat.Tag, fn func(offset uint32, e tcformat.TagEntry) bool) error {
+	if !tag.IsString() {
+		return fmt.Errorf("%s is not a string tag", tag)
+	}
+
+	file := r.files[tag]
+	for offset := tcformat.HeaderSize; offset < len(file); {
+		e, err := tcformat.ReadTagEntry(file[offset:], r.order)
+		if err != nil {
+			return err
+		}
+		if !fn(uint32(offset), e) {
+			return nil
+		}
+		offset += e.Size()
+	}
+	return nil
+}
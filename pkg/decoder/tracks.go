@@ -0,0 +1,62 @@
+package decoder
+
+import (
+	"rbdbtools/pkg/tcformat"
+	"rbdbtools/pkg/track"
+	"time"
+)
+
+// TrackOptions set how DecodedDatabases.Tracks converts index entries to tracks
+type TrackOptions struct {
+	// IncludeDeleted keeps entries flagged as deleted, they are skipped otherwise
+	IncludeDeleted bool
+	// TimeZone is the time zone of the player's clock the mtimes were encoded in,
+	// UTC when nil
+	TimeZone *time.Location
+}
+
+// Track converts the entry to a track with its statistics and flags. version
+// is the format version of the database the entry is from.
+func (e Entry) Track(timeZone *time.Location, version uint32) track.Track {
+	if timeZone == nil {
+		timeZone = time.UTC
+	}
+
+	return track.Track{
+		Artist:      e.Artist,
+		Album:       e.Album,
+		Genre:       e.Genre,
+		Title:       e.Title,
+		Filename:    e.Filename,
+		Composer:    e.Composer,
+		Comment:     e.Comment,
+		AlbumArtist: e.AlbumArtist,
+		Grouping:    e.Grouping,
+		Year:        e.Year,
+		Disc:        e.DiscNumber,
+		Track:       e.TrackNumber,
+		Bitrate:     e.Bitrate,
+		Length:      e.Length,
+		Mtime:       tcformat.DecodeMtime(e.Mtime, timeZone, version),
+		PlayCount:   e.PlayCount,
+		Rating:      e.Rating,
+		PlayTime:    e.PlayTime,
+		LastPlayed:  e.LastPlayed,
+		LastElapsed: e.LastElapsed,
+		LastOffset:  e.LastOffset,
+		CommitId:    e.CommitId,
+		Flags:       uint32(e.Flags),
+	}
+}
+
+// Tracks converts the entries of the index to tracks, in index order
+func (db *DecodedDatabases) Tracks(opts TrackOptions) []track.Track {
+	tracks := make([]track.Track, 0, len(db.Index.Entries))
+	for _, e := range db.Index.Entries {
+		if e.Flags.Has(FlagDeleted) && !opts.IncludeDeleted {
+			continue
+		}
+		tracks = append(tracks, e.Track(opts.TimeZone, db.Index.Magic))
+	}
+	return tracks
+}
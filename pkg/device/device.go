@@ -0,0 +1,178 @@
+// Package device identifies the player a database is built for from its
+// .rockbox directory, so the database is built in a format the player can read.
+package device
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"rbdbtools/pkg/decoder"
+	"rbdbtools/pkg/tcformat"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// InfoName is the file Rockbox writes into .rockbox describing the build
+const InfoName = "rockbox-info.txt"
+
+// DefaultExternalVolume is the name most players with a card slot give it
+const DefaultExternalVolume = "<microSD1>"
+
+var (
+	UnknownTargetError        = errors.New("cannot tell which player this is")
+	IncompatibleDatabaseError = errors.New("existing database can't be read by this player")
+	releaseVersion            = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+	lastFatMtimeRelease       = [2]int{3, 15}
+	bigEndianCPUs             = map[string]bool{"coldfire": true, "sh": true, "sh1": true}
+	littleEndianCPUs          = map[string]bool{"arm": true, "mips": true}
+)
+
+// Info is what rockbox-info.txt says about the build installed on a player
+type Info struct {
+	Target       string
+	TargetId     int
+	CPU          string
+	Manufacturer string
+	Version      string
+	Memory       int
+	Features     []string
+}
+
+// ReadInfo reads rockbox-info.txt from rockboxDir
+func ReadInfo(rockboxDir string) (Info, error) {
+	f, err := os.Open(path.Join(rockboxDir, InfoName))
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+
+	info := Info{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		value := strings.TrimSpace(kv[1])
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "target":
+			info.Target = value
+		case "target id":
+			info.TargetId, _ = strconv.Atoi(value)
+		case "cpu":
+			info.CPU = strings.ToLower(value)
+		case "manufacturer":
+			info.Manufacturer = value
+		case "version":
+			info.Version = value
+		case "memory":
+			info.Memory, _ = strconv.Atoi(value)
+		case "features":
+			for _, e := range strings.Split(value, ":") {
+				if e = strings.TrimSpace(e); e != "" {
+					info.Features = append(info.Features, e)
+				}
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return Info{}, err
+	}
+
+	if info.Target == "" {
+		return Info{}, fmt.Errorf("%s does not name a target", InfoName)
+	}
+	return info, nil
+}
+
+// FormatVersion is the tagcache version the build writes. Releases up to 3.15
+// store mtimes as FAT dates, later releases and development builds don't.
+func (i Info) FormatVersion() uint32 {
+	m := releaseVersion.FindStringSubmatch(i.Version)
+	if m == nil {
+		return tcformat.Magic
+	}
+
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	if major < lastFatMtimeRelease[0] || (major == lastFatMtimeRelease[0] && minor <= lastFatMtimeRelease[1]) {
+		return tcformat.OldestMagic
+	}
+	return tcformat.Magic
+}
+
+// Profile is how a database has to be built for a player
+type Profile struct {
+	// Target is the name Rockbox gives the player, empty when only an existing
+	// database was found
+	Target    string
+	BigEndian bool
+	Version   uint32
+	// ExternalVolume is the name the player gives its card, paths on it start
+	// with it. Empty when the player has no card slot.
+	ExternalVolume string
+}
+
+func (p Profile) String() string {
+	endian := "little"
+	if p.BigEndian {
+		endian = "big"
+	}
+
+	target := p.Target
+	if target == "" {
+		target = "unknown player"
+	}
+	return fmt.Sprintf("%s, %s endian, format version 0x%08X", target, endian, p.Version)
+}
+
+// Detect works out the profile of the player whose .rockbox directory is
+// rockboxDir, from rockbox-info.txt and any database already there. A database
+// the player wrote itself is trusted for the format version, but one in the
+// wrong byte order for the target is refused.
+func Detect(rockboxDir string) (Profile, error) {
+	info, infoErr := ReadInfo(rockboxDir)
+	if infoErr != nil && !os.IsNotExist(infoErr) {
+		return Profile{}, infoErr
+	}
+	dbBigEndian, dbVersion, dbErr := decoder.DetectEndianness(rockboxDir)
+	if dbErr != nil && !errors.Is(dbErr, decoder.NoDatabasesError) {
+		return Profile{}, dbErr
+	}
+	hasInfo, hasDatabase := infoErr == nil, dbErr == nil
+
+	p := Profile{
+		Target:         info.Target,
+		ExternalVolume: DefaultExternalVolume,
+	}
+
+	if !hasInfo && !hasDatabase {
+		return Profile{}, fmt.Errorf("%w: %s has neither %s nor a database", UnknownTargetError, rockboxDir, InfoName)
+	} else if !hasInfo {
+		p.BigEndian, p.Version = dbBigEndian, dbVersion
+		return p, nil
+	}
+
+	p.Version = info.FormatVersion()
+	if t, known := Lookup(info.Target); known {
+		p.BigEndian, p.ExternalVolume = t.BigEndian, t.ExternalVolume
+	} else if bigEndianCPUs[info.CPU] || littleEndianCPUs[info.CPU] {
+		p.BigEndian = bigEndianCPUs[info.CPU]
+	} else if hasDatabase {
+		p.BigEndian = dbBigEndian
+	} else {
+		return Profile{}, fmt.Errorf("%w: %s is not a known target", UnknownTargetError, info.Target)
+	}
+
+	if hasDatabase {
+		if dbBigEndian != p.BigEndian {
+			return Profile{}, fmt.Errorf("%w: it is in the other byte order to %s", IncompatibleDatabaseError, info.Target)
+		}
+		p.Version = dbVersion
+	}
+	return p, nil
+}
@@ -0,0 +1,192 @@
+package device
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"rbdbtools/pkg/tcformat"
+	"reflect"
+	"testing"
+)
+
+// rockboxDir makes a .rockbox directory holding info as rockbox-info.txt, if
+// not empty, and an index file of the given magic, if not 0
+func rockboxDir(t *testing.T, info string, bigEndian bool, magic uint32) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "rbdbdevice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	if info != "" {
+		err = ioutil.WriteFile(path.Join(dir, InfoName), []byte(info), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if magic != 0 {
+		index := make([]byte, 12)
+		tcformat.ByteOrder(bigEndian).PutUint32(index, magic)
+		err = ioutil.WriteFile(path.Join(dir, tcformat.IndexFilename), index, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestReadInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want Info
+		ok   bool
+	}{
+		{
+			name: "full",
+			in: "Target: ipodvideo\nTarget id: 26\nTarget define: IPOD_VIDEO\nMemory: 32\nCPU: ARM\n" +
+				"Manufacturer: Apple\nVersion: v3.15\nBinary: rockbox.ipod\nBinary size: 1234\n" +
+				"Features: tagcache:lcd_color: touchscreen\n",
+			want: Info{
+				Target:       "ipodvideo",
+				TargetId:     26,
+				CPU:          "arm",
+				Manufacturer: "Apple",
+				Version:      "v3.15",
+				Memory:       32,
+				Features:     []string{"tagcache", "lcd_color", "touchscreen"},
+			},
+			ok: true,
+		},
+		{
+			name: "spacing and case",
+			in:   "target :  iriverh300 \r\nCPU:Coldfire\r\nversion: 1a2b3c-230101\r\n",
+			want: Info{Target: "iriverh300", CPU: "coldfire", Version: "1a2b3c-230101"},
+			ok:   true,
+		},
+		{
+			name: "no target",
+			in:   "CPU: arm\nVersion: v3.15\n",
+		},
+		{
+			name: "empty",
+			in:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := rockboxDir(t, tt.in, false, 0)
+			if tt.in == "" {
+				ioutil.WriteFile(path.Join(dir, InfoName), nil, 0644)
+			}
+
+			info, err := ReadInfo(dir)
+			if (err == nil) != tt.ok {
+				t.Fatalf("ReadInfo() error = %v, want ok %t", err, tt.ok)
+			}
+			if tt.ok && !reflect.DeepEqual(info, tt.want) {
+				t.Errorf("ReadInfo() = %+v, want %+v", info, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadInfoMissing(t *testing.T) {
+	_, err := ReadInfo(rockboxDir(t, "", false, 0))
+	if !os.IsNotExist(err) {
+		t.Errorf("ReadInfo() error = %v, want not exist", err)
+	}
+}
+
+func TestFormatVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint32
+	}{
+		{"v3.14", tcformat.OldestMagic},
+		{"v3.15", tcformat.OldestMagic},
+		{"3.15", tcformat.OldestMagic},
+		{"v2.5", tcformat.OldestMagic},
+		{"v3.16", tcformat.Magic},
+		{"v4.0", tcformat.Magic},
+		{"abc123-230101", tcformat.Magic},
+		{"", tcformat.Magic},
+	}
+
+	for _, tt := range tests {
+		if got := (Info{Version: tt.version}).FormatVersion(); got != tt.want {
+			t.Errorf("FormatVersion(%q) = 0x%08X, want 0x%08X", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name      string
+		info      string
+		bigEndian bool
+		magic     uint32
+		want      Profile
+		err       error
+	}{
+		{
+			name: "info only",
+			info: "Target: iriverh300\nCPU: coldfire\nVersion: v3.15\n",
+			want: Profile{Target: "iriverh300", BigEndian: true, Version: tcformat.OldestMagic},
+		},
+		{
+			name: "info only, unknown target",
+			info: "Target: newplayer\nCPU: arm\nVersion: v4.0\n",
+			want: Profile{Target: "newplayer", Version: tcformat.Magic, ExternalVolume: DefaultExternalVolume},
+		},
+		{
+			name:      "database only",
+			bigEndian: true,
+			magic:     tcformat.OldestMagic,
+			want:      Profile{BigEndian: true, Version: tcformat.OldestMagic, ExternalVolume: DefaultExternalVolume},
+		},
+		{
+			name:  "database overrides info version",
+			info:  "Target: creativezenxfi3\nCPU: arm\nVersion: v3.15\n",
+			magic: tcformat.Magic,
+			want:  Profile{Target: "creativezenxfi3", Version: tcformat.Magic, ExternalVolume: "<microSD1>"},
+		},
+		{
+			name:      "byte order mismatch",
+			info:      "Target: ipodvideo\nCPU: arm\nVersion: v3.15\n",
+			bigEndian: true,
+			magic:     tcformat.Magic,
+			err:       IncompatibleDatabaseError,
+		},
+		{
+			name: "unknown target and cpu",
+			info: "Target: newplayer\nCPU: z80\nVersion: v4.0\n",
+			err:  UnknownTargetError,
+		},
+		{
+			name: "neither",
+			err:  UnknownTargetError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Detect(rockboxDir(t, tt.info, tt.bigEndian, tt.magic))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Detect() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Detect() error = %v", err)
+			}
+			if p != tt.want {
+				t.Errorf("Detect() = %+v, want %+v", p, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"rbdbtools/pkg/tcformat"
+	"strings"
+)
+
+// RockboxDirName is the directory at the root of a player's internal volume Rockbox keeps its files in
+const RockboxDirName = ".rockbox"
+
+var (
+	NoRockboxError    = errors.New("no .rockbox directory found")
+	NotOnDeviceError  = errors.New("directory is not on the player")
+	leftoverFilenames = []string{tcformat.TempFilename, tcformat.StateFilename}
+)
+
+// Mount is the internal volume of a player mounted on this machine
+type Mount struct {
+	// Root is the absolute path of the volume, the player sees it as /
+	Root       string
+	RockboxDir string
+}
+
+// FindMount locates the .rockbox directory of the player mounted at mountpoint
+func FindMount(mountpoint string) (Mount, error) {
+	root, err := filepath.Abs(mountpoint)
+	if err != nil {
+		return Mount{}, err
+	}
+
+	rockboxDir := path.Join(root, RockboxDirName)
+	if fi, err := os.Stat(rockboxDir); err != nil || !fi.IsDir() {
+		return Mount{}, fmt.Errorf("%w in %s", NoRockboxError, root)
+	}
+	return Mount{Root: root, RockboxDir: rockboxDir}, nil
+}
+
+// Within returns the absolute path of dir, which must be on the volume
+func (m Mount) Within(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(m.Root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s is not in %s", NotOnDeviceError, dir, m.Root)
+	}
+	return abs, nil
+}
+
+// Leftovers are the files the player keeps beside its database that describe
+// the database they came from: tracks it hasn't committed yet and its saved
+// ramcache. The player would apply them to a new database, so they have to be
+// moved out of the way when one is installed.
+func Leftovers() []string {
+	return append([]string(nil), leftoverFilenames...)
+}
+
+// Leftovers returns the names of the leftovers there are on the player
+func (m Mount) Leftovers() []string {
+	found := make([]string, 0)
+	for _, e := range leftoverFilenames {
+		if _, err := os.Stat(path.Join(m.RockboxDir, e)); err == nil {
+			found = append(found, e)
+		}
+	}
+	return found
+}
@@ -0,0 +1,77 @@
+package device
+
+// Target is a player Rockbox runs on
+type Target struct {
+	// Name is the target name in rockbox-info.txt
+	Name      string
+	BigEndian bool
+	// ExternalVolume is the name the player gives its card, empty if it has no card slot
+	ExternalVolume string
+}
+
+// Lookup returns the target named in rockbox-info.txt
+func Lookup(name string) (Target, bool) {
+	for _, t := range targets {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Target{}, false
+}
+
+// Targets is every player whose format is known without a database to go by
+func Targets() []Target {
+	return append([]Target(nil), targets...)
+}
+
+var targets = []Target{
+	// SH1
+	{Name: "archosplayer", BigEndian: true},
+	{Name: "archosrecorder", BigEndian: true},
+	{Name: "archosfmrecorder", BigEndian: true},
+	{Name: "archosrecorderv2", BigEndian: true},
+	{Name: "archosondiosp", BigEndian: true, ExternalVolume: "<MMC1>"},
+	{Name: "archosondiofm", BigEndian: true, ExternalVolume: "<MMC1>"},
+
+	// ColdFire
+	{Name: "iriverh100", BigEndian: true},
+	{Name: "iriverh120", BigEndian: true},
+	{Name: "iriverh300", BigEndian: true},
+	{Name: "iaudiox5", BigEndian: true},
+	{Name: "iaudiom5", BigEndian: true},
+	{Name: "iaudiom3", BigEndian: true},
+	{Name: "mpiohd200", BigEndian: true},
+	{Name: "mpiohd300", BigEndian: true},
+
+	// ARM
+	{Name: "ipod1g2g"},
+	{Name: "ipod3g"},
+	{Name: "ipod4g"},
+	{Name: "ipodcolor"},
+	{Name: "ipodmini1g"},
+	{Name: "ipodmini2g"},
+	{Name: "ipodnano1g"},
+	{Name: "ipodnano2g"},
+	{Name: "ipodvideo"},
+	{Name: "ipod6g"},
+	{Name: "iriverh10"},
+	{Name: "iriverh10_5gb"},
+	{Name: "gigabeatfx"},
+	{Name: "gigabeats"},
+	{Name: "sansae200", ExternalVolume: "<microSD1>"},
+	{Name: "sansac200", ExternalVolume: "<microSD1>"},
+	{Name: "sansae200v2", ExternalVolume: "<microSD1>"},
+	{Name: "sansac200v2", ExternalVolume: "<microSD1>"},
+	{Name: "sansafuze", ExternalVolume: "<microSD1>"},
+	{Name: "sansafuzev2", ExternalVolume: "<microSD1>"},
+	{Name: "sansafuzeplus", ExternalVolume: "<microSD1>"},
+	{Name: "sansaclip"},
+	{Name: "sansaclipv2"},
+	{Name: "sansaclipplus", ExternalVolume: "<microSD1>"},
+	{Name: "sansaclipzip", ExternalVolume: "<microSD1>"},
+	{Name: "creativezenxfi2", ExternalVolume: "<microSD1>"},
+	{Name: "creativezenxfi3", ExternalVolume: "<microSD1>"},
+
+	// MIPS
+	{Name: "xduoox3", ExternalVolume: "<microSD1>"},
+}
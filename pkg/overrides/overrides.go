@@ -0,0 +1,202 @@
+package overrides
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"rbdbtools/pkg/track"
+	"strings"
+)
+
+// SidecarName is the name of the per-directory override file
+const SidecarName = ".rbdbtags.json"
+
+// Tags holds the values to override, nil fields are left untouched
+type Tags struct {
+	Artist      *string `json:"artist,omitempty"`
+	Album       *string `json:"album,omitempty"`
+	Genre       *string `json:"genre,omitempty"`
+	Title       *string `json:"title,omitempty"`
+	Composer    *string `json:"composer,omitempty"`
+	Comment     *string `json:"comment,omitempty"`
+	AlbumArtist *string `json:"albumArtist,omitempty"`
+	Grouping    *string `json:"grouping,omitempty"`
+	Year        *uint32 `json:"year,omitempty"`
+	Disc        *uint32 `json:"disc,omitempty"`
+	TotalDiscs  *uint32 `json:"totalDiscs,omitempty"`
+	Track       *uint32 `json:"track,omitempty"`
+	TotalTracks *uint32 `json:"totalTracks,omitempty"`
+
+	ArtistSort      *string `json:"artistSort,omitempty"`
+	AlbumSort       *string `json:"albumSort,omitempty"`
+	AlbumArtistSort *string `json:"albumArtistSort,omitempty"`
+	TitleSort       *string `json:"titleSort,omitempty"`
+	ComposerSort    *string `json:"composerSort,omitempty"`
+}
+
+// Rule applies Tags to every file whose path relative to the override file
+// (or, for the central file, the library root) matches Match. A pattern also
+// matches everything below a matching directory, and an empty pattern matches
+// every file.
+type Rule struct {
+	Match string `json:"match"`
+	Tags  Tags   `json:"tags"`
+}
+
+type Overrides struct {
+	root     string
+	central  []Rule
+	sidecars map[string][]Rule
+}
+
+// New creates overrides for the library at root. centralFile may be empty,
+// otherwise it is a JSON list of rules relative to root.
+func New(root string, centralFile string) (Overrides, error) {
+	o := Overrides{
+		root:     filepath.Clean(root),
+		sidecars: make(map[string][]Rule),
+	}
+
+	if centralFile != "" {
+		rules, err := readRules(centralFile)
+		if err != nil {
+			return Overrides{}, err
+		}
+		o.central = rules
+	}
+
+	return o, nil
+}
+
+// Apply overrides the tags of t, filename is the location of the track on disk.
+// Central rules are applied first, then sidecars from the root down to the
+// directory containing the file, so the most specific rule wins.
+func (o *Overrides) Apply(filename string, t *track.Track) error {
+	filename = filepath.Clean(filename)
+	rel, err := filepath.Rel(o.root, filename)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil
+	}
+	rel = filepath.ToSlash(rel)
+
+	applyRules(o.central, rel, t)
+
+	dirs := []string{""}
+	if d := path.Dir(rel); d != "." {
+		for _, e := range strings.Split(d, "/") {
+			dirs = append(dirs, path.Join(dirs[len(dirs)-1], e))
+		}
+	}
+
+	for _, dir := range dirs {
+		rules, err := o.sidecar(dir)
+		if err != nil {
+			return err
+		}
+
+		sub := rel
+		if dir != "" {
+			sub = strings.TrimPrefix(rel, dir+"/")
+		}
+		applyRules(rules, sub, t)
+	}
+
+	return nil
+}
+
+func (o *Overrides) sidecar(dir string) ([]Rule, error) {
+	if rules, exists := o.sidecars[dir]; exists {
+		return rules, nil
+	}
+
+	rules, err := readRules(filepath.Join(o.root, filepath.FromSlash(dir), SidecarName))
+	if os.IsNotExist(err) {
+		rules, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	o.sidecars[dir] = rules
+	return rules, nil
+}
+
+func readRules(filename string) ([]Rule, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]Rule, 0)
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func applyRules(rules []Rule, rel string, t *track.Track) {
+	for _, r := range rules {
+		if matches(r.Match, rel) {
+			r.Tags.apply(t)
+		}
+	}
+}
+
+func matches(pattern string, rel string) bool {
+	if pattern == "" {
+		return true
+	}
+	pattern = strings.TrimSuffix(pattern, "/")
+
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (tags Tags) apply(t *track.Track) {
+	strs := []struct {
+		from *string
+		to   *string
+	}{
+		{tags.Artist, &t.Artist},
+		{tags.Album, &t.Album},
+		{tags.Genre, &t.Genre},
+		{tags.Title, &t.Title},
+		{tags.Composer, &t.Composer},
+		{tags.Comment, &t.Comment},
+		{tags.AlbumArtist, &t.AlbumArtist},
+		{tags.Grouping, &t.Grouping},
+		{tags.ArtistSort, &t.ArtistSort},
+		{tags.AlbumSort, &t.AlbumSort},
+		{tags.AlbumArtistSort, &t.AlbumArtistSort},
+		{tags.TitleSort, &t.TitleSort},
+		{tags.ComposerSort, &t.ComposerSort},
+	}
+	for _, e := range strs {
+		if e.from != nil {
+			*e.to = *e.from
+		}
+	}
+
+	nums := []struct {
+		from *uint32
+		to   *uint32
+	}{
+		{tags.Year, &t.Year},
+		{tags.Disc, &t.Disc},
+		{tags.TotalDiscs, &t.TotalDiscs},
+		{tags.Track, &t.Track},
+		{tags.TotalTracks, &t.TotalTracks},
+	}
+	for _, e := range nums {
+		if e.from != nil {
+			*e.to = *e.from
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package tcformat
+
+import "time"
+
+// fatMtimeMaxVer is the last format version whose mtime is the FAT directory
+// entry's packed date and time, later versions store it as seconds since 1970
+// of the FAT (local) time
+const fatMtimeMaxVer = 0x5443480E
+
+// EncodeMtime converts a unix timestamp to the mtime Rockbox compares against
+// the directory entry when scanning for changed files. FAT stores local time at
+// a two second resolution, loc is the time zone the player's clock is set to.
+func EncodeMtime(unix uint32, loc *time.Location, version uint32) uint32 {
+	t := time.Unix(int64(unix), 0).In(loc)
+
+	// FAT can't store dates before 1980
+	if t.Year() < 1980 {
+		t = time.Date(1980, time.January, 1, 0, 0, 0, 0, loc)
+	}
+
+	if version <= fatMtimeMaxVer {
+		date := uint32(t.Year()-1980)<<9 | uint32(t.Month())<<5 | uint32(t.Day())
+		clock := uint32(t.Hour())<<11 | uint32(t.Minute())<<5 | uint32(t.Second()/2)
+		return date<<16 | clock
+	}
+
+	local := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second()-t.Second()%2, 0, time.UTC)
+	return uint32(local.Unix())
+}
+
+// DecodeMtime is the inverse of EncodeMtime, returning a unix timestamp
+func DecodeMtime(mtime uint32, loc *time.Location, version uint32) uint32 {
+	var t time.Time
+	if version <= fatMtimeMaxVer {
+		date, clock := mtime>>16, mtime&0xFFFF
+		t = time.Date(int(date>>9)+1980, time.Month((date>>5)&0x0F), int(date&0x1F),
+			int(clock>>11), int((clock>>5)&0x3F), int(clock&0x1F)*2, 0, loc)
+	} else {
+		u := time.Unix(int64(mtime), 0).UTC()
+		t = time.Date(u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), 0, loc)
+	}
+	return uint32(t.Unix())
+}
@@ -0,0 +1,365 @@
+// Package tcformat is the on-disk layout of Rockbox's tagcache database. Both
+// the decoder and the generator use it, so reading and writing can't disagree.
+package tcformat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Magic is the version every database file starts with
+const Magic = 0x5443480F
+
+// OldestMagic is the oldest version with the same layout, it only differs by
+// how mtimes are stored
+const OldestMagic = 0x5443480E
+
+const (
+	HeaderSize         = 12
+	IndexHeaderSize    = 24
+	TagEntryHeaderSize = 8
+	IndexEntrySize     = (int(TagCount) + 1) * 4
+
+	IndexFilename = "database_idx.tcd"
+	// TempFilename holds tracks the player found but hasn't committed yet
+	TempFilename = "database_tmp.tcd"
+	// StateFilename is where the player saves its ramcache of the database between boots
+	StateFilename = "database_state.tcd"
+
+	// UniqueIdx is the index held by tags that are shared between tracks
+	UniqueIdx = 0xFFFFFFFF
+	// PaddingByte pads the tags that aren't titles or filenames to 8 bytes
+	PaddingByte = 'X'
+)
+
+// Flags of an index entry
+const (
+	FlagDeleted     = 1 << 0
+	FlagDirCache    = 1 << 1
+	FlagDirty       = 1 << 2
+	FlagTrackNumGen = 1 << 3
+	FlagResurrected = 1 << 4
+)
+
+var (
+	ShortRecordError        = errors.New("record is shorter than its size")
+	NotTagcacheError        = errors.New("file is not a tagcache database")
+	UnsupportedVersionError = errors.New("format version is not supported")
+)
+
+// magicPrefix is "TCH" followed by the version in the low byte of a magic
+const (
+	magicPrefix = 0x54434800
+	magicMask   = 0xFFFFFF00
+)
+
+// Tag is a field of an index entry, in the order they are stored
+type Tag int
+
+const (
+	Artist Tag = iota
+	Album
+	Genre
+	Title
+	Filename
+	Composer
+	Comment
+	AlbumArtist
+	Grouping
+	Year
+	DiscNumber
+	TrackNumber
+	Bitrate
+	Length
+	PlayCount
+	Rating
+	PlayTime
+	LastPlayed
+	CommitId
+	Mtime
+	LastElapsed
+	LastOffset
+	TagCount
+
+	// StringTagCount is the number of tags that are strings, each has its own file
+	// and the index holds their offsets into it
+	StringTagCount = Grouping + 1
+)
+
+var tagNames = [TagCount]string{
+	Artist:      "artist",
+	Album:       "album",
+	Genre:       "genre",
+	Title:       "title",
+	Filename:    "filename",
+	Composer:    "composer",
+	Comment:     "comment",
+	AlbumArtist: "album artist",
+	Grouping:    "grouping",
+	Year:        "year",
+	DiscNumber:  "disc number",
+	TrackNumber: "track number",
+	Bitrate:     "bitrate",
+	Length:      "length",
+	PlayCount:   "play count",
+	Rating:      "rating",
+	PlayTime:    "play time",
+	LastPlayed:  "last played",
+	CommitId:    "commit id",
+	Mtime:       "mtime",
+	LastElapsed: "last elapsed",
+	LastOffset:  "last offset",
+}
+
+func (t Tag) String() string {
+	if t < 0 || t >= TagCount {
+		return fmt.Sprintf("tag %d", int(t))
+	}
+	return tagNames[t]
+}
+
+// IsString is whether the tag is a string kept in its own file
+func (t Tag) IsString() bool {
+	return t >= 0 && t < StringTagCount
+}
+
+// Unique is whether every track has its own entry of the tag, these entries hold
+// the index of their track and aren't padded
+func (t Tag) Unique() bool {
+	return t == Title || t == Filename
+}
+
+// Filename is the name of the file the tag is kept in, numeric tags are in the index
+func (t Tag) Filename() string {
+	if !t.IsString() {
+		return IndexFilename
+	}
+	return fmt.Sprintf("database_%d.tcd", int(t))
+}
+
+// TagOfFilename returns the string tag kept in the named file
+func TagOfFilename(name string) (Tag, bool) {
+	for t := Tag(0); t < StringTagCount; t++ {
+		if t.Filename() == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// Filenames returns the names of the files a database is made of, the index last
+func Filenames() []string {
+	files := make([]string, 0, StringTagCount+1)
+	for t := Tag(0); t < StringTagCount; t++ {
+		files = append(files, t.Filename())
+	}
+	return append(files, IndexFilename)
+}
+
+// Padding is the number of padding bytes after a tag of length bytes and its terminator
+func Padding(length int) int {
+	if p := (length + 1) % 8; p != 0 {
+		return 8 - p
+	}
+	return 0
+}
+
+func ByteOrder(bigEndian bool) binary.ByteOrder {
+	if bigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// SupportedVersion is whether a database of version can be read and written
+func SupportedVersion(version uint32) bool {
+	return version >= OldestMagic && version <= Magic
+}
+
+// ReadMagic detects the byte order of a database file from the magic it starts
+// with, returning it and the version. Files of other tagcache versions return
+// UnsupportedVersionError along with their byte order and version.
+func ReadMagic(b []byte) (binary.ByteOrder, uint32, error) {
+	if len(b) < 4 {
+		return nil, 0, ShortRecordError
+	}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		version := order.Uint32(b)
+		if version&magicMask != magicPrefix {
+			continue
+		} else if !SupportedVersion(version) {
+			return order, version, fmt.Errorf("%w: 0x%08X", UnsupportedVersionError, version)
+		}
+		return order, version, nil
+	}
+	return nil, 0, NotTagcacheError
+}
+
+// Header starts every database file, for tag files size is the size of the file
+// after the header
+type Header struct {
+	Magic   uint32
+	Size    uint32
+	Entries uint32
+}
+
+func ReadHeader(b []byte, order binary.ByteOrder) (Header, error) {
+	if len(b) < HeaderSize {
+		return Header{}, ShortRecordError
+	}
+	return Header{
+		Magic:   order.Uint32(b[0:4]),
+		Size:    order.Uint32(b[4:8]),
+		Entries: order.Uint32(b[8:12]),
+	}, nil
+}
+
+func (h Header) Put(b []byte, order binary.ByteOrder) {
+	order.PutUint32(b[0:4], h.Magic)
+	order.PutUint32(b[4:8], h.Size)
+	order.PutUint32(b[8:12], h.Entries)
+}
+
+// IndexHeader starts the index, the serial goes up with every play and the
+// commit id with every commit of the database
+type IndexHeader struct {
+	Header
+	Serial   uint32
+	CommitId uint32
+	Dirty    bool
+}
+
+func ReadIndexHeader(b []byte, order binary.ByteOrder) (IndexHeader, error) {
+	if len(b) < IndexHeaderSize {
+		return IndexHeader{}, ShortRecordError
+	}
+	header, _ := ReadHeader(b, order)
+	return IndexHeader{
+		Header:   header,
+		Serial:   order.Uint32(b[12:16]),
+		CommitId: order.Uint32(b[16:20]),
+		Dirty:    order.Uint32(b[20:24]) != 0,
+	}, nil
+}
+
+func (h IndexHeader) Put(b []byte, order binary.ByteOrder) {
+	h.Header.Put(b, order)
+	order.PutUint32(b[12:16], h.Serial)
+	order.PutUint32(b[16:20], h.CommitId)
+	dirty := uint32(0)
+	if h.Dirty {
+		dirty = 1
+	}
+	order.PutUint32(b[20:24], dirty)
+}
+
+// TagEntry is an entry of a tag file, length counts the data, its terminator and padding
+type TagEntry struct {
+	Length  uint32
+	Idx     uint32
+	Data    string
+	Padding int
+}
+
+// NewTagEntry makes an entry for data, padded unless the tag is unique
+func NewTagEntry(data string, idx uint32, tag Tag) TagEntry {
+	e := TagEntry{
+		Idx:  idx,
+		Data: data,
+	}
+	if !tag.Unique() {
+		e.Padding = Padding(len(data))
+	}
+	e.Length = uint32(len(data) + 1 + e.Padding)
+	return e
+}
+
+// ReadTagEntry reads the entry at the start of b, the data ends at the first
+// terminator within its length
+func ReadTagEntry(b []byte, order binary.ByteOrder) (TagEntry, error) {
+	if len(b) < TagEntryHeaderSize {
+		return TagEntry{}, ShortRecordError
+	}
+	e := TagEntry{
+		Length: order.Uint32(b[0:4]),
+		Idx:    order.Uint32(b[4:8]),
+	}
+	if uint64(len(b)) < TagEntryHeaderSize+uint64(e.Length) {
+		return TagEntry{}, ShortRecordError
+	}
+
+	data := b[TagEntryHeaderSize : TagEntryHeaderSize+int(e.Length)]
+	for end, c := range data {
+		if c == 0x00 {
+			e.Data = string(data[:end])
+			e.Padding = len(data) - (end + 1)
+			break
+		}
+	}
+	return e, nil
+}
+
+// Size is the number of bytes the entry takes up in its file
+func (e TagEntry) Size() int {
+	return TagEntryHeaderSize + int(e.Length)
+}
+
+var (
+	terminator = []byte{0x00}
+	padding    = []byte{PaddingByte, PaddingByte, PaddingByte, PaddingByte, PaddingByte, PaddingByte, PaddingByte, PaddingByte}
+)
+
+func (e TagEntry) Write(w io.Writer, order binary.ByteOrder) error {
+	var header [TagEntryHeaderSize]byte
+	order.PutUint32(header[0:4], e.Length)
+	order.PutUint32(header[4:8], e.Idx)
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, e.Data); err != nil {
+		return err
+	}
+	if _, err := w.Write(terminator); err != nil {
+		return err
+	}
+
+	for rest := e.Padding; rest > 0; {
+		n := rest
+		if n > len(padding) {
+			n = len(padding)
+		}
+		if _, err := w.Write(padding[:n]); err != nil {
+			return err
+		}
+		rest -= n
+	}
+	return nil
+}
+
+// IndexEntry is an entry of the index, string tags hold offsets into their files
+type IndexEntry struct {
+	Tags  [TagCount]uint32
+	Flags uint32
+}
+
+func ReadIndexEntry(b []byte, order binary.ByteOrder) (IndexEntry, error) {
+	if len(b) < IndexEntrySize {
+		return IndexEntry{}, ShortRecordError
+	}
+	e := IndexEntry{}
+	for k := range e.Tags {
+		e.Tags[k] = order.Uint32(b[k*4:])
+	}
+	e.Flags = order.Uint32(b[TagCount*4:])
+	return e, nil
+}
+
+func (e IndexEntry) Put(b []byte, order binary.ByteOrder) {
+	for k, v := range e.Tags {
+		order.PutUint32(b[k*4:], v)
+	}
+	order.PutUint32(b[TagCount*4:], e.Flags)
+}
@@ -0,0 +1,79 @@
+package track
+
+import (
+	"regexp"
+	"strconv"
+)
+
+var (
+	// Matches "3", "03", "3/12", "3 of 12" and "Disc 2"
+	numberPattern = regexp.MustCompile(`(\d+)(?:\s*(?:/|of)\s*(\d+))?`)
+	// Matches the year of "2001", "2001-05-03", "2001-05-03T12:00:00" and "20010503"
+	yearPattern = regexp.MustCompile(`^\s*(\d{4})(?:\d{4})?(?:\D|$)`)
+)
+
+// rawTags are the number and date tags as the file stores them, taglib maps
+// every container's tags (ID3v2 TRCK/TPOS/TDRC, Vorbis comments, MP4 trkn/disk/©day
+// etc.) to these
+type rawTags struct {
+	trackNumber string
+	trackTotal  string
+	discNumber  string
+	discTotal   string
+	date        string
+}
+
+// apply sets the numbers and year of t from the tags that could be parsed,
+// separate totals win over ones given with the number
+func (r rawTags) apply(t *Track) {
+	if n, total, ok := parseNumber(r.trackNumber); ok {
+		t.Track, t.TotalTracks = n, total
+	}
+	if total, _, ok := parseNumber(r.trackTotal); ok {
+		t.TotalTracks = total
+	}
+	if n, total, ok := parseNumber(r.discNumber); ok {
+		t.Disc, t.TotalDiscs = n, total
+	}
+	if total, _, ok := parseNumber(r.discTotal); ok {
+		t.TotalDiscs = total
+	}
+	if y, ok := parseYear(r.date); ok {
+		t.Year = y
+	}
+}
+
+// parseNumber parses a track or disc number tag, returning the number and,
+// if the tag includes one, the total. ok is false when no number is found.
+func parseNumber(s string) (number uint32, total uint32, ok bool) {
+	m := numberPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, false
+	}
+
+	n, err := strconv.ParseUint(m[1], 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	if m[2] != "" {
+		if t, err := strconv.ParseUint(m[2], 10, 32); err == nil {
+			total = uint32(t)
+		}
+	}
+
+	return uint32(n), total, true
+}
+
+// parseYear parses the year out of a year or ISO 8601 date tag.
+func parseYear(s string) (uint32, bool) {
+	m := yearPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+
+	y, err := strconv.ParseUint(m[1], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(y), true
+}
@@ -0,0 +1,102 @@
+package track
+
+import "testing"
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		in     string
+		number uint32
+		total  uint32
+		ok     bool
+	}{
+		{"3", 3, 0, true},
+		{"03", 3, 0, true},
+		{"3/12", 3, 12, true},
+		{"03/12", 3, 12, true},
+		{"3 / 12", 3, 12, true},
+		{"3 of 12", 3, 12, true},
+		{"Disc 2", 2, 0, true},
+		{" 7 ", 7, 0, true},
+		{"", 0, 0, false},
+		{"none", 0, 0, false},
+		{"99999999999", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		number, total, ok := parseNumber(tt.in)
+		if number != tt.number || total != tt.total || ok != tt.ok {
+			t.Errorf("parseNumber(%q) = %d, %d, %t, want %d, %d, %t", tt.in, number, total, ok, tt.number, tt.total, tt.ok)
+		}
+	}
+}
+
+func TestParseYear(t *testing.T) {
+	tests := []struct {
+		in   string
+		year uint32
+		ok   bool
+	}{
+		{"2001", 2001, true},
+		{" 2001", 2001, true},
+		{"2001-05-03", 2001, true},
+		{"2001-05-03T12:00:00", 2001, true},
+		{"2001-05", 2001, true},
+		{"20010503", 2001, true},
+		{"", 0, false},
+		{"01", 0, false},
+		{"200105", 0, false},
+		{"May 2001", 0, false},
+	}
+
+	for _, tt := range tests {
+		year, ok := parseYear(tt.in)
+		if year != tt.year || ok != tt.ok {
+			t.Errorf("parseYear(%q) = %d, %t, want %d, %t", tt.in, year, ok, tt.year, tt.ok)
+		}
+	}
+}
+
+// TestRawTagsApply covers the tags as taglib hands them over from each container.
+// Reading them out of real files needs taglib, so only the parsing is covered.
+func TestRawTagsApply(t *testing.T) {
+	tests := []struct {
+		format string
+		raw    rawTags
+		// before is what the track had from taglib's basic tag interface
+		before Track
+		want   Track
+	}{
+		{"ID3v2.3 TRCK/TPOS/TYER", rawTags{trackNumber: "03/12", discNumber: "1/2", date: "2001"},
+			Track{}, Track{Track: 3, TotalTracks: 12, Disc: 1, TotalDiscs: 2, Year: 2001}},
+		{"ID3v2.4 TDRC", rawTags{trackNumber: "3", discNumber: "2", date: "2001-05-03T12:00:00"},
+			Track{}, Track{Track: 3, Disc: 2, Year: 2001}},
+		{"ID3v2.4 TDRC compact", rawTags{trackNumber: "3", date: "20010503"},
+			Track{}, Track{Track: 3, Year: 2001}},
+		{"ID3v2 Disc 2", rawTags{discNumber: "Disc 2"},
+			Track{}, Track{Disc: 2}},
+		{"Vorbis DATE and totals", rawTags{trackNumber: "3", trackTotal: "12", discNumber: "2", discTotal: "3", date: "2001-05-03"},
+			Track{}, Track{Track: 3, TotalTracks: 12, Disc: 2, TotalDiscs: 3, Year: 2001}},
+		{"Vorbis total overrides", rawTags{trackNumber: "3/10", trackTotal: "12"},
+			Track{}, Track{Track: 3, TotalTracks: 12}},
+		{"Vorbis Disc 2", rawTags{discNumber: "Disc 2", discTotal: "2"},
+			Track{}, Track{Disc: 2, TotalDiscs: 2}},
+		{"MP4 trkn/disk", rawTags{trackNumber: "3/12", discNumber: "2/2", date: "2001-05-03T07:00:00Z"},
+			Track{}, Track{Track: 3, TotalTracks: 12, Disc: 2, TotalDiscs: 2, Year: 2001}},
+		{"MP4 Disc 2", rawTags{discNumber: "Disc 2"},
+			Track{}, Track{Disc: 2}},
+		{"APE 3 of 12", rawTags{trackNumber: "3 of 12", date: "2001"},
+			Track{}, Track{Track: 3, TotalTracks: 12, Year: 2001}},
+		{"nothing parseable", rawTags{trackNumber: "x", discNumber: "", date: "unknown"},
+			Track{Track: 9, Year: 1999}, Track{Track: 9, Year: 1999}},
+	}
+
+	for _, tt := range tests {
+		got := tt.before
+		tt.raw.apply(&got)
+		if got != tt.want {
+			t.Errorf("%s: got track %d/%d disc %d/%d year %d, want track %d/%d disc %d/%d year %d", tt.format,
+				got.Track, got.TotalTracks, got.Disc, got.TotalDiscs, got.Year,
+				tt.want.Track, tt.want.TotalTracks, tt.want.Disc, tt.want.TotalDiscs, tt.want.Year)
+		}
+	}
+}
@@ -8,15 +8,20 @@
 
 #define UNTAGGED "<Untagged>"
 
+// Copies str as UTF-8, sized by its encoded length rather than its character count
+char* copyRawString(TagLib::String str) {
+    std::string utf8 = str.to8Bit(true);
+    char* s = (char*) calloc(utf8.size() + 1, sizeof(char));
+    if (s == NULL) return NULL;
+    memcpy(s, utf8.c_str(), utf8.size());
+    return s;
+}
+
 char* copyString(TagLib::String str) {
-    bool untagged = str.size() == 0;
-    if (untagged) {
+    if (str.size() == 0) {
         str = TagLib::String(UNTAGGED);
     }
-    char* s = (char*) calloc(str.size() + 1, sizeof(char));
-    if (s == NULL) return NULL;
-    memcpy(s, untagged ? UNTAGGED : str.toCString(true), str.size());
-    return s;
+    return copyRawString(str);
 }
 
 struct track* getTrack(char* filename) {
@@ -29,7 +34,7 @@ struct track* getTrack(char* filename) {
         struct track* t = (struct track*) calloc(1, sizeof(struct track));
         if (t == NULL) return NULL;
 
-        t->filename = copyString(TagLib::String(filename));
+        t->filename = copyString(TagLib::String(filename, TagLib::String::UTF8));
         if (t->filename == NULL) return (struct track*)  freeTrack(t);
 
         if (tag) {
@@ -56,48 +61,58 @@ struct track* getTrack(char* filename) {
         if (t->grouping == NULL) return (struct track*) freeTrack(t);
         t->disc = 0;
 
+        // Raw number and date strings are parsed on the go side, sort tags are left empty when missing
+        char** raw[] = {&t->trackNumber, &t->trackTotal, &t->discNumber, &t->discTotal, &t->date,
+                        &t->artistSort, &t->albumSort, &t->albumArtistSort, &t->titleSort, &t->composerSort};
+        for (int j = 0; j < 10; j++) {
+            *raw[j] = (char*) calloc(1, sizeof(char));
+            if (*raw[j] == NULL) return (struct track*) freeTrack(t);
+        }
+
         TagLib::PropertyMap tags = file.file()->properties();
 
-        // composer, albumArtist, grouping, disc
-        bool found[] = {false, false, false, false};
+        // composer, albumArtist, grouping
+        bool found[] = {false, false, false};
         for(TagLib::PropertyMap::ConstIterator i = tags.begin(); i != tags.end(); ++i) {
-            if (found[0] && found[1] && found[2] && found[3]) break;
-
             TagLib::String upper = i->first.upper();
-            if (upper == "COMPOSER") {
+            if (!found[0] && upper == "COMPOSER") {
                 found[0] = true;
                 free(t->composer);
                 t->composer = copyString(i->second.toString());
                 if (t->composer == NULL) return (struct track*) freeTrack(t);
             }
-            if (upper == "ALBUMARTIST" || upper == "ALBUM ARTIST" || upper == "BAND" || upper == "ENSEMBLE") {
+            if (!found[1] && (upper == "ALBUMARTIST" || upper == "ALBUM ARTIST" || upper == "BAND" || upper == "ENSEMBLE")) {
                 found[1] = true;
                 free(t->albumArtist);
                 t->albumArtist = copyString(i->second.toString());
                 if (t->albumArtist == NULL) return (struct track*) freeTrack(t);
             }
-            if (upper == "GROUPING" || upper == "ITUNES GROUPING") {
+            if (!found[2] && (upper == "GROUPING" || upper == "ITUNES GROUPING")) {
                 found[2] = true;
                 free(t->grouping);
                 t->grouping = copyString(i->second.toString());
                 if (t->grouping == NULL) return (struct track*) freeTrack(t);
             }
-            if (upper == "DISCNUMBER" || upper == "DISC NUMBER") {
-                found[3] = true;
-                unsigned len = i->second.toString().size();
-                const char* strNum = i->second.toString().toCString(true);
-                for (int j = 0; j < len; j++) {
-                    switch (strNum[j]) {
-                        case '0': case '1': case '2': case '3': case '4':
-                        case '5': case '6': case '7': case '8': case '9':
-                            t->disc *= 10;
-                            t->disc += strNum[j] - '0';
-                            continue;
-                        default:
-                            j = 1;
-                            len = 0;
-                    }
-                }
+
+            if (upper == "COMPILATION") {
+                t->compilation = i->second.toString().toInt() != 0;
+            }
+
+            char** dst = NULL;
+            if (upper == "TRACKNUMBER") dst = &t->trackNumber;
+            else if (upper == "TRACKTOTAL" || upper == "TOTALTRACKS") dst = &t->trackTotal;
+            else if (upper == "DISCNUMBER" || upper == "DISC NUMBER") dst = &t->discNumber;
+            else if (upper == "DISCTOTAL" || upper == "TOTALDISCS") dst = &t->discTotal;
+            else if (upper == "DATE" || (upper == "YEAR" && (*t->date) == '\0')) dst = &t->date;
+            else if (upper == "ARTISTSORT") dst = &t->artistSort;
+            else if (upper == "ALBUMSORT") dst = &t->albumSort;
+            else if (upper == "ALBUMARTISTSORT") dst = &t->albumArtistSort;
+            else if (upper == "TITLESORT") dst = &t->titleSort;
+            else if (upper == "COMPOSERSORT") dst = &t->composerSort;
+            if (dst != NULL) {
+                free(*dst);
+                *dst = copyRawString(i->second.toString());
+                if (*dst == NULL) return (struct track*) freeTrack(t);
             }
         }
 
@@ -142,6 +157,16 @@ void* freeTrack(struct track* track) {
     free(track->albumArtist);
     free(track->grouping);
     free(track->filename);
+    free(track->trackNumber);
+    free(track->trackTotal);
+    free(track->discNumber);
+    free(track->discTotal);
+    free(track->date);
+    free(track->artistSort);
+    free(track->albumSort);
+    free(track->albumArtistSort);
+    free(track->titleSort);
+    free(track->composerSort);
     free(track);
     return nullptr;
 }
@@ -25,12 +25,39 @@ type Track struct {
 	Grouping    string
 	Year        uint32
 	Disc        uint32
+	TotalDiscs  uint32
 	Track       uint32
+	TotalTracks uint32
 	Bitrate     uint32
 	Length      uint32
+	// Mtime is the unix time the file was last modified, the database encodes
+	// it the way the player does
 	Mtime       uint32
+	Compilation bool
+
+	ArtistSort      string
+	AlbumSort       string
+	AlbumArtistSort string
+	TitleSort       string
+	ComposerSort    string
+
+	// Statistics kept by the player
+	PlayCount   uint32
+	Rating      uint32
+	PlayTime    uint32
+	LastPlayed  uint32
+	LastElapsed uint32
+	LastOffset  uint32
+	// CommitId is the commit of the database the track was added in, 0 until
+	// it has been saved
+	CommitId uint32
+	// Flags of the index entry, see tcformat.FlagDeleted etc.
+	Flags uint32
 }
 
+// Untagged is the value given to string tags missing from a file
+const Untagged = "<Untagged>"
+
 var GetTrackError = errors.New("failed to get tracks")
 
 func New(filename string) (Track, error) {
@@ -113,13 +140,28 @@ func cTrackToTrack(track *C.struct_track) (Track, error) {
 		Bitrate:     uint32(track.bitrate),
 		Length:      uint32(track.length),
 		Mtime:       mtime,
+		Compilation: track.compilation != 0,
+
+		ArtistSort:      C.GoString(track.artistSort),
+		AlbumSort:       C.GoString(track.albumSort),
+		AlbumArtistSort: C.GoString(track.albumArtistSort),
+		TitleSort:       C.GoString(track.titleSort),
+		ComposerSort:    C.GoString(track.composerSort),
 	}
 
-	if t.AlbumArtist == "" || t.AlbumArtist == "<Untagged>" {
+	rawTags{
+		trackNumber: C.GoString(track.trackNumber),
+		trackTotal:  C.GoString(track.trackTotal),
+		discNumber:  C.GoString(track.discNumber),
+		discTotal:   C.GoString(track.discTotal),
+		date:        C.GoString(track.date),
+	}.apply(&t)
+
+	if t.AlbumArtist == "" || t.AlbumArtist == Untagged {
 		t.AlbumArtist = t.Artist
 	}
 
-	if t.Grouping == "" || t.Grouping == "<Untagged>" {
+	if t.Grouping == "" || t.Grouping == Untagged {
 		t.Grouping = t.Title
 	}
 
@@ -17,11 +17,22 @@ struct track {
     char* comment;
     char* albumArtist;
     char* grouping;
+    char* trackNumber;
+    char* trackTotal;
+    char* discNumber;
+    char* discTotal;
+    char* date;
+    char* artistSort;
+    char* albumSort;
+    char* albumArtistSort;
+    char* titleSort;
+    char* composerSort;
     unsigned year;
     unsigned disc;
     unsigned track;
     unsigned bitrate;
     unsigned length;
+    unsigned compilation;
 };
 
 struct tracks {